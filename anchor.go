@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gogolok/gsheet-updater/internal/a1"
+	"google.golang.org/api/sheets/v4"
+)
+
+// anchor locates a report by a named range or by developer metadata instead
+// of fixed coordinates, so it follows the cells when rows or columns are
+// inserted. At most one of the fields is set.
+type anchor struct {
+	rangeName   string
+	metadataKey string
+}
+
+func (a anchor) isSet() bool {
+	return len(a.rangeName) > 0 || len(a.metadataKey) > 0
+}
+
+func (a anchor) String() string {
+	if len(a.rangeName) > 0 {
+		return fmt.Sprintf("named range %s", a.rangeName)
+	}
+	return fmt.Sprintf("developer metadata %s", a.metadataKey)
+}
+
+// Looks up the range the anchor points to. Developer metadata is attached to
+// whole rows or whole columns and results in such a range.
+func (a anchor) resolve(srv *sheets.Service, spreadsheetId string) (a1.Range, error) {
+	ss, err := srv.Spreadsheets.Get(spreadsheetId).Fields("sheets.properties", "namedRanges").Do()
+	if err != nil {
+		return a1.Range{}, err
+	}
+	titles := map[int64]string{}
+	for _, sh := range ss.Sheets {
+		titles[sh.Properties.SheetId] = sh.Properties.Title
+	}
+
+	if len(a.rangeName) > 0 {
+		for _, nr := range ss.NamedRanges {
+			if nr.Name != a.rangeName {
+				continue
+			}
+			gr := nr.Range
+			r := a1.Range{
+				Sheet:    titles[gr.SheetId],
+				StartRow: int(gr.StartRowIndex),
+				StartCol: int(gr.StartColumnIndex),
+				EndRow:   a1.Unbounded,
+				EndCol:   a1.Unbounded,
+			}
+			if gr.EndRowIndex > 0 {
+				r.EndRow = int(gr.EndRowIndex) - 1
+			}
+			if gr.EndColumnIndex > 0 {
+				r.EndCol = int(gr.EndColumnIndex) - 1
+			}
+			return r, nil
+		}
+		return a1.Range{}, fmt.Errorf("No named range %s in spreadsheet %s.", a.rangeName, spreadsheetId)
+	}
+
+	resp, err := srv.Spreadsheets.DeveloperMetadata.Search(spreadsheetId, &sheets.SearchDeveloperMetadataRequest{
+		DataFilters: []*sheets.DataFilter{{
+			DeveloperMetadataLookup: &sheets.DeveloperMetadataLookup{MetadataKey: a.metadataKey},
+		}},
+	}).Do()
+	if err != nil {
+		return a1.Range{}, err
+	}
+	if len(resp.MatchedDeveloperMetadata) != 1 {
+		return a1.Range{}, fmt.Errorf("Expected one location with developer metadata %s, found %d.", a.metadataKey, len(resp.MatchedDeveloperMetadata))
+	}
+
+	location := resp.MatchedDeveloperMetadata[0].DeveloperMetadata.Location
+	dr := location.DimensionRange
+	if dr == nil {
+		return a1.Range{}, fmt.Errorf("Developer metadata %s must be attached to rows or columns, not %s.", a.metadataKey, location.LocationType)
+	}
+	r := a1.Range{Sheet: titles[dr.SheetId]}
+	if dr.Dimension == "ROWS" {
+		r.StartRow, r.EndRow = int(dr.StartIndex), int(dr.EndIndex)-1
+		r.StartCol, r.EndCol = 0, a1.Unbounded
+	} else {
+		r.StartRow, r.EndRow = 0, a1.Unbounded
+		r.StartCol, r.EndCol = int(dr.StartIndex), int(dr.EndIndex)-1
+	}
+	return r, nil
+}
+
+// Returns the top left cell of the anchored range. Whole rows only fix the
+// row and whole columns only the column, the other one is taken from def.
+func anchoredCell(r a1.Range, def a1.Range) a1.Range {
+	switch {
+	case r.StartCol == 0 && r.EndCol == a1.Unbounded && r.EndRow != a1.Unbounded:
+		return a1.Cell(r.Sheet, r.StartRow, def.StartCol)
+	case r.StartRow == 0 && r.EndRow == a1.Unbounded && r.EndCol != a1.Unbounded:
+		return a1.Cell(r.Sheet, def.StartRow, r.StartCol)
+	default:
+		return a1.Cell(r.Sheet, r.StartRow, r.StartCol)
+	}
+}
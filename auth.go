@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const defaultProfile = "default"
+
+var (
+	// googleRevokeURL revokes access and refresh tokens.
+	googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+	// googleTokenInfoURL describes the identity and scopes of an access token.
+	googleTokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+)
+
+// profile is a named OAuth client and token stored below the user's config
+// directory, e.g. ~/.config/gsheet-updater/default/token.json.
+type profile struct {
+	name string
+	dir  string
+}
+
+// Returns the directory all profiles are stored in.
+func profilesDir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if len(dir) < 1 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("Unable to locate config directory: %v", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "gsheet-updater"), nil
+}
+
+func openProfile(name string) (profile, error) {
+	if len(name) < 1 || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return profile{}, fmt.Errorf("Invalid profile name %q", name)
+	}
+
+	dir, err := profilesDir()
+	if err != nil {
+		return profile{}, err
+	}
+	return profile{name: name, dir: filepath.Join(dir, name)}, nil
+}
+
+func (p profile) tokenFile() string {
+	return filepath.Join(p.dir, "token.json")
+}
+
+func (p profile) credentialsFile() string {
+	return filepath.Join(p.dir, "credentials.json")
+}
+
+func newAuthCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage stored OAuth logins",
+		Long:  `Manage the OAuth clients and tokens stored per profile in $XDG_CONFIG_HOME/gsheet-updater/.`,
+	}
+
+	cmd.AddCommand(newAuthLoginCmd())
+	cmd.AddCommand(newAuthLogoutCmd())
+	cmd.AddCommand(newAuthStatusCmd())
+	cmd.AddCommand(newAuthListCmd())
+
+	return cmd
+}
+
+func newAuthLoginCmd() *cobra.Command {
+	var clientSecret string
+
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in and store the token in the profile",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authLogin(clientOpts, clientSecret)
+		},
+	}
+
+	cmd.Flags().StringVar(&clientSecret, "client-secret", "", "OAuth client JSON file to store in the profile")
+
+	return cmd
+}
+
+func newAuthLogoutCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Revoke and delete the token of the profile",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authLogout(clientOpts)
+		},
+	}
+}
+
+func newAuthStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:         "status",
+		Short:       "Show identity, scopes and expiry of the profile's token",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{readOnlyAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authStatus(clientOpts, os.Stdout)
+		},
+	}
+}
+
+func newAuthListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List stored profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authList(clientOpts, os.Stdout)
+		},
+	}
+}
+
+func authLogin(options *clientOptions, clientSecret string) error {
+	p, err := openProfile(options.profile)
+	if err != nil {
+		return err
+	}
+
+	if len(clientSecret) > 0 {
+		b, err := ioutil.ReadFile(clientSecret)
+		if err != nil {
+			return fmt.Errorf("Unable to read client secret file: %v", err)
+		}
+		if err := os.MkdirAll(p.dir, 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p.credentialsFile(), b, 0600); err != nil {
+			return fmt.Errorf("Unable to store client secret file: %v", err)
+		}
+	}
+
+	config, err := oauthConfig(p, options)
+	if err != nil {
+		return err
+	}
+
+	tok, err := login(config, options)
+	if err != nil {
+		return err
+	}
+	if err := saveToken(p.tokenStore(), tok); err != nil {
+		return err
+	}
+
+	fmt.Printf("Logged in with profile %s\n", p.name)
+	return nil
+}
+
+func authLogout(options *clientOptions) error {
+	p, err := openProfile(options.profile)
+	if err != nil {
+		return err
+	}
+
+	store := p.tokenStore()
+	tok, err := store.Load()
+	if os.IsNotExist(err) {
+		fmt.Printf("Profile %s is not logged in\n", p.name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read token: %v", err)
+	}
+
+	if err := revokeToken(context.Background(), tok); err != nil {
+		log.Warnf("Unable to revoke token, deleting it anyway: %v", err)
+	}
+	for _, path := range []string{p.tokenFile(), p.encryptedTokenFile()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	fmt.Printf("Logged out of profile %s\n", p.name)
+	return nil
+}
+
+type tokenInfo struct {
+	Email     string `json:"email"`
+	Scope     string `json:"scope"`
+	ExpiresIn string `json:"expires_in"`
+	Error     string `json:"error_description"`
+}
+
+func authStatus(options *clientOptions, out io.Writer) error {
+	p, err := openProfile(options.profile)
+	if err != nil {
+		return err
+	}
+
+	store := p.tokenStore()
+	tok, err := store.Load()
+	if os.IsNotExist(err) {
+		fmt.Fprintf(out, "Profile:  %s\nStatus:   not logged in\n", p.name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read token: %v", err)
+	}
+
+	config, err := oauthConfig(p, options)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tok, err = newPersistingTokenSource(ctx, config, store, tok).Token()
+	if err != nil {
+		return fmt.Errorf("Unable to refresh token, run auth login again: %v", err)
+	}
+
+	info, err := fetchTokenInfo(ctx, tok.AccessToken)
+	if err != nil {
+		return err
+	}
+
+	identity := info.Email
+	if len(identity) < 1 {
+		identity = "unknown (token has no email scope, run auth login again)"
+	}
+
+	fmt.Fprintf(out, "Profile:  %s\n", p.name)
+	fmt.Fprintf(out, "Identity: %s\n", identity)
+	fmt.Fprintf(out, "Scopes:   %s\n", strings.Join(strings.Fields(info.Scope), ", "))
+	fmt.Fprintf(out, "Expiry:   %s\n", tok.Expiry.Format(time.RFC3339))
+	return nil
+}
+
+func authList(options *clientOptions, out io.Writer) error {
+	dir, err := profilesDir()
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		p := profile{name: entry.Name(), dir: filepath.Join(dir, entry.Name())}
+		marker := " "
+		if p.name == options.profile {
+			marker = "*"
+		}
+		status := "not logged in"
+		if _, err := os.Stat(p.tokenFile()); err == nil {
+			status = "logged in"
+		}
+		if _, err := os.Stat(p.encryptedTokenFile()); err == nil {
+			status = "logged in (encrypted)"
+		}
+		fmt.Fprintf(out, "%s %s\t%s\n", marker, p.name, status)
+	}
+	return nil
+}
+
+// Revokes the refresh token, or the access token if there is none. Revoking
+// a refresh token also invalidates the access tokens issued for it.
+func revokeToken(ctx context.Context, tok *oauth2.Token) error {
+	token := tok.RefreshToken
+	if len(token) < 1 {
+		token = tok.AccessToken
+	}
+
+	req, err := http.NewRequest(http.MethodPost, googleRevokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revoke endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+func fetchTokenInfo(ctx context.Context, accessToken string) (tokenInfo, error) {
+	var info tokenInfo
+
+	req, err := http.NewRequest(http.MethodGet, googleTokenInfoURL+"?"+url.Values{"access_token": {accessToken}}.Encode(), nil)
+	if err != nil {
+		return info, err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return info, fmt.Errorf("Unable to fetch token info: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return info, fmt.Errorf("Unable to parse token info: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return info, errors.New("Token info rejected the token: " + info.Error)
+	}
+	return info, nil
+}
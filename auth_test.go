@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const testClientSecret = `{"installed":{"client_id":"client","client_secret":"secret","auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":"https://oauth2.googleapis.com/token","redirect_uris":["http://localhost"]}}`
+
+// Points the profiles at a temporary config directory and returns the
+// default profile holding the test OAuth client.
+func testProfile(t *testing.T) profile {
+	t.Helper()
+	dir := t.TempDir()
+	setenv(t, "XDG_CONFIG_HOME", dir)
+
+	p, err := openProfile(defaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(p.dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(p.dir, "credentials.json"), []byte(testClientSecret), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestOAuthConfigRequestsIdentity(t *testing.T) {
+	p := testProfile(t)
+
+	config, err := oauthConfig(p, &clientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := strings.Join(config.Scopes, " ")
+	for _, scope := range []string{"openid", "email", "https://www.googleapis.com/auth/spreadsheets"} {
+		if !strings.Contains(scopes, scope) {
+			t.Errorf("scopes %q lack %s", scopes, scope)
+		}
+	}
+}
+
+func TestAuthStatusShowsIdentity(t *testing.T) {
+	p := testProfile(t)
+	tok := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	if err := writeToken(p.tokenFile(), tok); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("access_token") != "access" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error_description":"Invalid Value"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(tokenInfo{Email: "ann@example.com", Scope: "openid https://www.googleapis.com/auth/userinfo.email", ExpiresIn: "3599"})
+	}))
+	defer srv.Close()
+	defer func(url string) { googleTokenInfoURL = url }(googleTokenInfoURL)
+	googleTokenInfoURL = srv.URL
+
+	var out strings.Builder
+	if err := authStatus(&clientOptions{profile: defaultProfile}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Identity: ann@example.com") {
+		t.Errorf("status output:\n%s", out.String())
+	}
+}
@@ -1,109 +1,579 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
 )
 
-func NewClient() (*http.Client, error) {
+const (
+	authBrowser = "browser"
+	authDevice  = "device"
+)
+
+type clientOptions struct {
+	// authMethod selects the interactive login for user credentials.
+	authMethod string
+	// credentialsFile is a service account key or other Google credentials
+	// JSON file. Falls back to GOOGLE_APPLICATION_CREDENTIALS.
+	credentialsFile string
+	// impersonate is the Workspace user a service account acts as through
+	// domain-wide delegation. Falls back to IMPERSONATE_USER.
+	impersonate string
+	// scopes overrides the OAuth scopes requested for the client.
+	scopes []string
+	// readOnly is set by commands that never write to the sheet, so they
+	// only ask for read access unless scopes is given.
+	readOnly bool
+	// profile names the stored OAuth client and token to use.
+	profile string
+	// apiEndpoint overrides the base URL of the Sheets API.
+	apiEndpoint string
+}
+
+func newClientOptions() *clientOptions {
+	return &clientOptions{
+		authMethod: authBrowser,
+		profile:    defaultProfile,
+	}
+}
+
+func (o *clientOptions) validate() error {
+	switch o.authMethod {
+	case authBrowser, authDevice:
+		return nil
+	default:
+		return fmt.Errorf("Unknown auth method %q, must be %q or %q", o.authMethod, authBrowser, authDevice)
+	}
+}
+
+// Returns the user to impersonate, if any.
+func (o *clientOptions) subject() string {
+	if len(o.impersonate) > 0 {
+		return o.impersonate
+	}
+	return os.Getenv("IMPERSONATE_USER")
+}
+
+// Returns the OAuth scopes to request. Short names like
+// "spreadsheets.readonly" are expanded to the full scope URL.
+func (o *clientOptions) scopeList() []string {
+	if len(o.scopes) < 1 {
+		if o.readOnly {
+			return []string{sheets.SpreadsheetsReadonlyScope}
+		}
+		return []string{sheets.SpreadsheetsScope}
+	}
+
+	scopes := make([]string, 0, len(o.scopes))
+	for _, scope := range o.scopes {
+		if !strings.Contains(scope, "/") {
+			scope = "https://www.googleapis.com/auth/" + scope
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// notConfiguredError is returned by an auth source that has nothing to load
+// credentials from, so the next source is tried.
+type notConfiguredError struct {
+	reason string
+}
+
+func (e notConfiguredError) Error() string {
+	return e.reason
+}
+
+type authSource struct {
+	name string
+	load func(ctx context.Context, options *clientOptions) (*http.Client, error)
+}
+
+// authSources lists the supported credential sources in the order they are
+// tried. The first configured source wins; a configured source that fails
+// to load aborts the detection.
+var authSources = []authSource{
+	{name: "credentials file (--credentials-file, GOOGLE_APPLICATION_CREDENTIALS)", load: credentialsFileClient},
+	{name: "service account (SERVICE_ACCOUNT, PRIVATE_KEY, PRIVATE_KEY_FILE)", load: serviceAccountClient},
+	{name: "OAuth client (CLIENT_SECRET, credentials.json of the profile)", load: oauthClient},
+	{name: "application default credentials", load: defaultCredentialsClient},
+}
+
+func NewClient(options *clientOptions) (*http.Client, error) {
+	ctx := context.Background()
+
+	tried := []string{}
+	for _, source := range authSources {
+		client, err := source.load(ctx, options)
+		if err == nil {
+			log.Infof("Using %s", source.name)
+			return client, nil
+		}
+
+		var notConfigured notConfiguredError
+		if !errors.As(err, &notConfigured) {
+			return nil, fmt.Errorf("%s: %v", source.name, err)
+		}
+		log.Debugf("Skipping %s: %v", source.name, err)
+		tried = append(tried, fmt.Sprintf("  - %s: %v", source.name, err))
+	}
+
+	return nil, fmt.Errorf("No credentials found, tried:\n%s", strings.Join(tried, "\n"))
+}
+
+// Returns a Sheets service sending its requests through client.
+func NewSheetsService(client *http.Client, options *clientOptions) (*sheets.Service, error) {
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if len(options.apiEndpoint) > 0 {
+		opts = append(opts, option.WithEndpoint(options.apiEndpoint))
+	}
+
+	srv, err := sheets.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create Sheets service: %v", err)
+	}
+	return srv, nil
+}
+
+func credentialsFileClient(ctx context.Context, options *clientOptions) (*http.Client, error) {
+	filename := options.credentialsFile
+	if len(filename) < 1 {
+		filename = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if len(filename) < 1 {
+		return nil, notConfiguredError{"no file given"}
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read credentials file: %v", err)
+	}
+
+	var key struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &key); err != nil {
+		return nil, fmt.Errorf("Unable to parse credentials file %s: %v", filename, err)
+	}
+
+	if key.Type == "service_account" {
+		conf, err := google.JWTConfigFromJSON(b, options.scopeList()...)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse service account key %s: %v", filename, err)
+		}
+		return jwtClient(ctx, conf, options), nil
+	}
+
+	if len(options.subject()) > 0 {
+		return nil, fmt.Errorf("Impersonation needs a service account key, %s is of type %q", filename, key.Type)
+	}
+
+	creds, err := google.CredentialsFromJSON(ctx, b, options.scopeList()...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse credentials file %s: %v", filename, err)
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), nil
+}
+
+func serviceAccountClient(ctx context.Context, options *clientOptions) (*http.Client, error) {
 	serviceAccount := os.Getenv("SERVICE_ACCOUNT")
-	if len(serviceAccount) > 0 {
-		privateKey := os.Getenv("PRIVATE_KEY")
-		if len(privateKey) < 1 {
-			return nil, errors.New("Environment variable PRIVATE_KEY not set")
+	if len(serviceAccount) < 1 {
+		return nil, notConfiguredError{"SERVICE_ACCOUNT not set"}
+	}
+
+	privateKey, err := servicePrivateKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(privateKey) < 1 {
+		p, err := openProfile(options.profile)
+		if err != nil {
+			return nil, err
 		}
+		privateKey, err = p.privateKey()
+		if os.IsNotExist(err) {
+			return nil, errors.New("Environment variable PRIVATE_KEY or PRIVATE_KEY_FILE not set and no private key in the credential store")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conf := &jwt.Config{
+		Email:      serviceAccount,
+		PrivateKey: privateKey,
+		Scopes:     options.scopeList(),
+		TokenURL:   google.JWTTokenURL,
+	}
 
-		conf := &jwt.Config{
-			Email:      serviceAccount,
-			PrivateKey: []byte(privateKey),
-			Scopes: []string{
-				"https://www.googleapis.com/auth/spreadsheets",
-			},
-			TokenURL: google.JWTTokenURL,
+	return jwtClient(ctx, conf, options), nil
+}
+
+// Returns the service account private key from PRIVATE_KEY_FILE or
+// PRIVATE_KEY, which may also hold a file:// or vault:// reference.
+func servicePrivateKey(ctx context.Context) ([]byte, error) {
+	if path := os.Getenv("PRIVATE_KEY_FILE"); len(path) > 0 {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read PRIVATE_KEY_FILE: %v", err)
 		}
+		return b, nil
+	}
+
+	if ref := os.Getenv("PRIVATE_KEY"); len(ref) > 0 {
+		return resolveSecret(ctx, ref)
+	}
+	return nil, nil
+}
+
+// Returns a client for a service account, acting on behalf of the
+// impersonated user if one is configured.
+func jwtClient(ctx context.Context, conf *jwt.Config, options *clientOptions) *http.Client {
+	conf.Subject = options.subject()
+	if len(conf.Subject) > 0 {
+		log.Infof("Authenticating as service account %s impersonating %s", conf.Email, conf.Subject)
+	} else {
+		log.Infof("Authenticating as service account %s", conf.Email)
+	}
+	return conf.Client(ctx)
+}
 
-		return conf.Client(oauth2.NoContext), nil
+func oauthClient(ctx context.Context, options *clientOptions) (*http.Client, error) {
+	p, err := openProfile(options.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := oauthConfig(p, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.subject()) > 0 {
+		return nil, errors.New("Impersonation needs service account credentials, not an OAuth client")
 	}
 
-	/**
-	 * Proceed with 'old' method if service account is not being used.
-	 */
+	return getClient(config, p, options)
+}
+
+// identityScopes are requested with every user login, so the token info
+// names the account.
+var identityScopes = []string{"openid", "email"}
 
-	b, err := ioutil.ReadFile("credentials.json")
+// Returns the OAuth client config from CLIENT_SECRET, which holds a file://
+// or vault:// reference, or the one stored for the profile. The
+// credentials.json in the current directory is used if the profile has none.
+func oauthConfig(p profile, options *clientOptions) (*oauth2.Config, error) {
+	var b []byte
+	var err error
+	if ref := os.Getenv("CLIENT_SECRET"); len(ref) > 0 {
+		b, err = resolveSecret(context.Background(), ref)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		b, err = ioutil.ReadFile(p.credentialsFile())
+	}
+	if os.IsNotExist(err) {
+		b, err = ioutil.ReadFile("credentials.json")
+	}
+	if os.IsNotExist(err) {
+		return nil, notConfiguredError{fmt.Sprintf("no credentials.json in %s or the current directory", p.dir)}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Unable to read client secret file: %v", err)
 	}
 
 	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, "https://www.googleapis.com/auth/spreadsheets")
+	// openid and email let auth status show who logged in.
+	config, err := google.ConfigFromJSON(b, append(options.scopeList(), identityScopes...)...)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to parse client secret file to config: %v", err)
 	}
+	return config, nil
+}
+
+func defaultCredentialsClient(ctx context.Context, options *clientOptions) (*http.Client, error) {
+	creds, err := google.FindDefaultCredentials(ctx, options.scopeList()...)
+	if err != nil {
+		return nil, notConfiguredError{err.Error()}
+	}
 
-	return getClient(config), nil
+	if len(options.subject()) > 0 {
+		// Only service account keys can be used with domain-wide delegation,
+		// so the default credentials must carry one.
+		conf, err := google.JWTConfigFromJSON(creds.JSON, options.scopeList()...)
+		if err != nil {
+			return nil, fmt.Errorf("Impersonation needs service account credentials: %v", err)
+		}
+		return jwtClient(ctx, conf, options), nil
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), nil
 }
 
 // Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
-	tokFile := "token.json"
-	tok, err := tokenFromFile(tokFile)
+func getClient(config *oauth2.Config, p profile, options *clientOptions) (*http.Client, error) {
+	// The token.json of the profile stores the user's access and refresh
+	// tokens, and is created automatically when the authorization flow
+	// completes for the first time.
+	store := p.tokenStore()
+	tok, err := store.Load()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Unable to read token: %v", err)
+	}
+	plaintext := ""
+	if err != nil {
+		// Plaintext tokens not yet imported into the encrypted store.
+		plaintext = p.tokenFile()
+		tok, err = tokenFromFile(plaintext)
+	}
+	if err != nil && p.name == defaultProfile {
+		// Tokens used to be kept in the current directory.
+		plaintext = "token.json"
+		tok, err = tokenFromFile(plaintext)
+	}
+	if _, ok := store.(encryptedTokenStore); ok && err == nil && len(plaintext) > 0 {
+		importPlaintextToken(store, tok, plaintext)
+	}
+	if err == nil && !tokenAllows(tok, options) {
+		log.Infof("The stored token only grants %s, logging in again to write", strings.Join(tokenScopes(tok), " "))
+		err = errors.New("token can't write")
+	}
 	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+		tok, err = login(config, options)
+		if err != nil {
+			return nil, err
+		}
+		if err := saveToken(store, tok); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := context.Background()
+	src := newPersistingTokenSource(ctx, config, store, tok)
+	return oauth2.NewClient(ctx, src), nil
+}
+
+// Reports whether a stored token can be used for the command. Commands that
+// write need a token granting it, tokens without recorded scopes are
+// trusted.
+func tokenAllows(tok *oauth2.Token, options *clientOptions) bool {
+	scopes := tokenScopes(tok)
+	return options.readOnly || len(scopes) < 1 || grantsWrite(scopes)
+}
+
+// Reports whether the scopes allow writing to spreadsheets.
+func grantsWrite(scopes []string) bool {
+	for _, scope := range scopes {
+		switch scope {
+		case sheets.SpreadsheetsScope, sheets.DriveScope, sheets.DriveFileScope:
+			return true
+		}
 	}
-	return config.Client(context.Background(), tok)
+	return false
 }
 
+// Runs the interactive login selected by the options. The token records
+// the scopes granted, or the requested ones if the answer didn't name them.
+func login(config *oauth2.Config, options *clientOptions) (*oauth2.Token, error) {
+	var tok *oauth2.Token
+	var err error
+	if options.authMethod == authDevice {
+		tok, err = newDeviceFlow(config, os.Stdout).login(context.Background())
+	} else {
+		tok, err = getTokenFromWeb(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(tokenScopes(tok)) < 1 {
+		tok = withScopes(tok, config.Scopes)
+	}
+	return tok, nil
+}
+
+// loginTimeout bounds how long we wait for the browser to come back to the
+// local redirect listener.
+var loginTimeout = 3 * time.Minute
+
 // Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
+func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+	tok, err := loopbackLogin(context.Background(), config, loginTimeout, openBrowser)
+	if err != nil {
+		return nil, fmt.Errorf("Browser login did not complete: %v. "+
+			"Open the printed link on this machine and make sure the OAuth client in "+
+			"credentials.json is of type \"Desktop app\", or use --auth=device on machines without a browser.", err)
+	}
+	return tok, nil
+}
 
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+// loopbackLogin runs the authorization code flow with PKCE against a
+// short-lived HTTP listener on 127.0.0.1 that serves as the redirect URI.
+// open is called with the authorization URL the user has to visit.
+func loopbackLogin(ctx context.Context, config *oauth2.Config, timeout time.Duration, open func(string)) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to start local redirect listener: %v", err)
 	}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
+	conf := *config
+	conf.RedirectURL = fmt.Sprintf("http://%s/", listener.Addr().String())
+
+	state, err := randomString(24)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	verifier, err := randomString(48)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		listener.Close()
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	type callbackResult struct {
+		code string
+		err  error
+	}
+	results := make(chan callbackResult, 1)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			query := req.URL.Query()
+			// Browsers and local tools may ask for other paths first, e.g.
+			// /favicon.ico. Only the redirect carrying an answer ends the
+			// login.
+			if req.URL.Path != "/" || query.Get("state") == "" && query.Get("code") == "" && query.Get("error") == "" {
+				http.NotFound(w, req)
+				return
+			}
+
+			var result callbackResult
+			switch {
+			case query.Get("state") != state:
+				result.err = errors.New("state mismatch in authorization response")
+			case query.Get("error") != "":
+				result.err = fmt.Errorf("authorization denied: %s", query.Get("error"))
+			case query.Get("code") == "":
+				result.err = errors.New("authorization response without code")
+			default:
+				result.code = query.Get("code")
+			}
+
+			if result.err != nil {
+				http.Error(w, result.err.Error(), http.StatusBadRequest)
+			} else {
+				fmt.Fprintln(w, "Authorization complete. You can close this window.")
+			}
+
+			select {
+			case results <- result:
+			default:
+			}
+		}),
 	}
-	return tok
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	authURL := conf.AuthCodeURL(state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+	open(authURL)
+
+	var result callbackResult
+	select {
+	case result = <-results:
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timed out after %v waiting for the browser redirect", timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	tok, err := conf.Exchange(ctx, result.code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to exchange authorization code: %v", err)
+	}
+	return tok, nil
+}
+
+// Prints the authorization URL and tries to open it in the default browser.
+func openBrowser(url string) {
+	fmt.Printf("Go to the following link in your browser to authorize access:\n%v\n", url)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err == nil {
+		go cmd.Wait()
+	}
+}
+
+// Returns a URL-safe random string built from n random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
 // Retrieves a token from a local file.
 func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
+	b, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
+	return decodeToken(b)
 }
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+// Moves a plaintext token into the encrypted store once a passphrase is
+// set, so no plaintext copy stays behind.
+func importPlaintextToken(store tokenStore, tok *oauth2.Token, path string) {
+	if err := store.Save(tok); err != nil {
+		log.Warnf("Unable to encrypt token %s, it stays in plaintext: %v", path, err)
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.Warnf("Encrypted token %s into %s, but unable to remove the plaintext file: %v", path, store.Path(), err)
+		return
+	}
+	log.Infof("Moved plaintext token %s into %s", path, store.Path())
+}
+
+// Saves a token to the store.
+func saveToken(store tokenStore, token *oauth2.Token) error {
+	fmt.Printf("Saving credential file to: %s\n", store.Path())
+	if err := store.Save(token); err != nil {
+		return fmt.Errorf("Unable to cache oauth token: %v", err)
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	return nil
 }
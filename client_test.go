@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/sheets/v4"
+)
+
+// fakeAuthServer is an authorization and token endpoint checking PKCE.
+type fakeAuthServer struct {
+	*httptest.Server
+	challenge string
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	s := &fakeAuthServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("code_challenge_method") != "S256" {
+			t.Errorf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+		}
+		s.challenge = query.Get("code_challenge")
+
+		redirect := query.Get("redirect_uri") + "?" + url.Values{
+			"code":  {"auth-code"},
+			"state": {query.Get("state")},
+		}.Encode()
+		http.Redirect(w, req, redirect, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		sum := sha256.Sum256([]byte(req.Form.Get("code_verifier")))
+		if req.Form.Get("code") != "auth-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != s.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *fakeAuthServer) config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   s.URL + "/auth",
+			TokenURL:  s.URL + "/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+func TestLoopbackLogin(t *testing.T) {
+	auth := newFakeAuthServer(t)
+	defer auth.Close()
+
+	open := func(authURL string) {
+		u, _ := url.Parse(authURL)
+		redirect := u.Query().Get("redirect_uri")
+
+		// A browser asking for the favicon first must not end the login.
+		resp, err := http.Get(redirect + "favicon.ico")
+		if err != nil {
+			t.Errorf("GET favicon.ico: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("favicon.ico status = %d, want 404", resp.StatusCode)
+		}
+
+		go func() {
+			resp, err := http.Get(authURL)
+			if err != nil {
+				t.Errorf("GET %s: %v", authURL, err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+
+	tok, err := loopbackLogin(context.Background(), auth.config(), 10*time.Second, open)
+	if err != nil {
+		t.Fatalf("loopbackLogin: %v", err)
+	}
+	if tok.AccessToken != "access" || tok.RefreshToken != "refresh" {
+		t.Errorf("token = %+v", tok)
+	}
+}
+
+func TestLoopbackLoginStateMismatch(t *testing.T) {
+	auth := newFakeAuthServer(t)
+	defer auth.Close()
+
+	open := func(authURL string) {
+		u, _ := url.Parse(authURL)
+		go http.Get(u.Query().Get("redirect_uri") + "?code=auth-code&state=forged")
+	}
+
+	_, err := loopbackLogin(context.Background(), auth.config(), 10*time.Second, open)
+	if err == nil {
+		t.Fatal("loopbackLogin accepted a forged state")
+	}
+}
+
+func TestReadOnlyCommands(t *testing.T) {
+	for _, args := range [][]string{{"auth", "status"}, {"rules", "test"}} {
+		cmd, _, err := rootCmd.Find(args)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if cmd.Annotations[readOnlyAnnotation] != "true" {
+			t.Errorf("%v is not annotated read-only", args)
+		}
+	}
+
+	options := &clientOptions{readOnly: true}
+	if scopes := options.scopeList(); grantsWrite(scopes) {
+		t.Errorf("read-only scopes %v grant writing", scopes)
+	}
+	if scopes := (&clientOptions{}).scopeList(); !grantsWrite(scopes) {
+		t.Errorf("default scopes %v don't grant writing", scopes)
+	}
+}
+
+func TestStoredTokenScopes(t *testing.T) {
+	p := testProfile(t)
+	store := p.tokenStore()
+
+	tok := withScopes(&oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}, []string{sheets.SpreadsheetsReadonlyScope, "openid"})
+	if err := store.Save(tok); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tokenScopes(loaded); !reflect.DeepEqual(got, tokenScopes(tok)) || loaded.RefreshToken != "refresh" {
+		t.Errorf("loaded token %+v with scopes %v, want %v", loaded, got, tokenScopes(tok))
+	}
+}
+
+func TestTokenAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		scopes   []string
+		readOnly bool
+		want     bool
+	}{
+		{name: "write token, write command", scopes: []string{sheets.SpreadsheetsScope}, want: true},
+		{name: "write token, read-only command", scopes: []string{sheets.SpreadsheetsScope}, readOnly: true, want: true},
+		{name: "read-only token, read-only command", scopes: []string{sheets.SpreadsheetsReadonlyScope}, readOnly: true, want: true},
+		{name: "read-only token, write command", scopes: []string{sheets.SpreadsheetsReadonlyScope}, want: false},
+		{name: "token without recorded scopes", want: true},
+	}
+	for _, tt := range tests {
+		tok := withScopes(&oauth2.Token{AccessToken: "access"}, tt.scopes)
+		if got := tokenAllows(tok, &clientOptions{readOnly: tt.readOnly}); got != tt.want {
+			t.Errorf("%s: tokenAllows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJWTClientImpersonation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var claims struct {
+		Iss   string `json:"iss"`
+		Sub   string `json:"sub"`
+		Scope string `json:"scope"`
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		if req.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("grant_type = %q", req.Form.Get("grant_type"))
+		}
+		parts := strings.Split(req.Form.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("assertion %q is not a JWT", req.Form.Get("assertion"))
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"service-access","token_type":"Bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer service-access" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	conf := &jwt.Config{
+		Email:      "robot@project.iam.gserviceaccount.com",
+		PrivateKey: pemKey,
+		Scopes:     []string{"https://www.googleapis.com/auth/spreadsheets"},
+		TokenURL:   srv.URL + "/token",
+	}
+	client := jwtClient(context.Background(), conf, &clientOptions{impersonate: "alice@example.com"})
+
+	resp, err := client.Get(srv.URL + "/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if claims.Iss != conf.Email || claims.Sub != "alice@example.com" || claims.Scope != conf.Scopes[0] {
+		t.Errorf("claims = %+v", claims)
+	}
+}
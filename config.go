@@ -0,0 +1,829 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gogolok/gsheet-updater/internal/a1"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/sheets/v4"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfigFile is read from the current directory if --config is not
+// given. It is optional.
+const defaultConfigFile = "gsheet-updater.yaml"
+
+const (
+	reportLane             = "lane"
+	reportHours            = "hours"
+	reportLastRunTimestamp = "last-run-timestamp"
+)
+
+var reportNames = []string{reportLane, reportHours, reportLastRunTimestamp}
+
+// configPath is the config file given with --config.
+var configPath = defaultConfigFile
+
+// fileConfig is the layout of the YAML config file. Settings in defaults
+// apply to every report unless the report overrides them:
+//
+//	defaults:
+//	  spreadsheet: 1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms
+//	  tab: Sprint 25
+//	reports:
+//	  lane:
+//	    file: lanes.csv
+//	  hours:
+//	    file: hoursbytag.csv
+//	    layout:
+//	      startColumn: G
+//	      maxEntries: 50
+type fileConfig struct {
+	Defaults reportConfig            `yaml:"defaults"`
+	Reports  map[string]reportConfig `yaml:"reports"`
+}
+
+type reportConfig struct {
+	Spreadsheet string       `yaml:"spreadsheet"`
+	Tab         string       `yaml:"tab"`
+	File        string       `yaml:"file"`
+	Profile     string       `yaml:"profile"`
+	Layout      layoutConfig `yaml:"layout"`
+	CSV         csvConfig    `yaml:"csv"`
+	// Strict fails the lane report if hours of tags without a lane would be
+	// lost.
+	Strict *bool `yaml:"strict"`
+	// Separator splits hierarchical tags like "project:alpha:backend".
+	Separator string `yaml:"separator"`
+	// Depth cuts hierarchical tags to their first segments, 0 keeps all.
+	Depth *int `yaml:"depth"`
+	// Rules is a YAML file of rules rolling tags of the input up into lanes.
+	Rules string `yaml:"rules"`
+	// Aliases is a YAML file mapping lanes to further tags of the input.
+	Aliases string `yaml:"aliases"`
+	// Suggest looks up similar lanes for tags without a lane.
+	Suggest *bool `yaml:"suggest"`
+	// InputFormat is the format of the input file, hours per tag by default.
+	InputFormat string `yaml:"inputFormat"`
+	// From and To select time entries by the day they start on, both
+	// inclusive, e.g. 2020-06-01.
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Billable selects time entries by their billable flag: all, billable or
+	// non-billable.
+	Billable string `yaml:"billable"`
+	// MultiTag splits the hours of time entries with several tags across
+	// them, or counts them fully for each tag: split or full.
+	MultiTag string `yaml:"multiTag"`
+}
+
+// layoutConfig holds the cell positions of all reports. Zero values keep
+// the report's default, unset flags are nil.
+type layoutConfig struct {
+	// all reports, anchor the report instead of its cell positions
+	RangeName   string `yaml:"rangeName"`
+	MetadataKey string `yaml:"metadataKey"`
+	// lane
+	Header         string `yaml:"header"`
+	TagColumn      string `yaml:"tagColumn"`
+	ValueColumn    string `yaml:"valueColumn"`
+	FirstRow       int    `yaml:"firstRow"`
+	Rows           int    `yaml:"rows"`
+	UnassignedLane string `yaml:"unassignedLane"`
+	// hours, also uses FirstRow
+	StartColumn string `yaml:"startColumn"`
+	MaxEntries  int    `yaml:"maxEntries"`
+	Outline     *bool  `yaml:"outline"`
+	// last-run-timestamp
+	Cell string `yaml:"cell"`
+}
+
+// csvConfig describes the columns and format of the input file.
+type csvConfig struct {
+	TagColumn      string `yaml:"tagColumn"`
+	HoursColumn    string `yaml:"hoursColumn"`
+	Delimiter      string `yaml:"delimiter"`
+	Comment        string `yaml:"comment"`
+	NoHeader       *bool  `yaml:"noHeader"`
+	DurationFormat string `yaml:"durationFormat"`
+	Locale         string `yaml:"locale"`
+}
+
+// Reads the config file. A missing file is only an error if required.
+func loadConfig(path string, required bool) (*fileConfig, error) {
+	c := &fileConfig{}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read config file: %v", err)
+	}
+
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("Unable to parse config file %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Returns c with the non-empty settings of o applied.
+func (c reportConfig) merge(o reportConfig) reportConfig {
+	if len(o.Spreadsheet) > 0 {
+		c.Spreadsheet = o.Spreadsheet
+	}
+	if len(o.Tab) > 0 {
+		c.Tab = o.Tab
+	}
+	if len(o.File) > 0 {
+		c.File = o.File
+	}
+	if len(o.Profile) > 0 {
+		c.Profile = o.Profile
+	}
+	c.Layout = c.Layout.merge(o.Layout)
+	c.CSV = c.CSV.merge(o.CSV)
+	if o.Strict != nil {
+		c.Strict = o.Strict
+	}
+	if len(o.Separator) > 0 {
+		c.Separator = o.Separator
+	}
+	if o.Depth != nil {
+		c.Depth = o.Depth
+	}
+	if len(o.Rules) > 0 {
+		c.Rules = o.Rules
+	}
+	if len(o.Aliases) > 0 {
+		c.Aliases = o.Aliases
+	}
+	if o.Suggest != nil {
+		c.Suggest = o.Suggest
+	}
+	if len(o.InputFormat) > 0 {
+		c.InputFormat = o.InputFormat
+	}
+	if len(o.From) > 0 {
+		c.From = o.From
+	}
+	if len(o.To) > 0 {
+		c.To = o.To
+	}
+	if len(o.Billable) > 0 {
+		c.Billable = o.Billable
+	}
+	if len(o.MultiTag) > 0 {
+		c.MultiTag = o.MultiTag
+	}
+	return c
+}
+
+func (l layoutConfig) merge(o layoutConfig) layoutConfig {
+	if len(o.RangeName) > 0 {
+		l.RangeName = o.RangeName
+	}
+	if len(o.MetadataKey) > 0 {
+		l.MetadataKey = o.MetadataKey
+	}
+	if len(o.Header) > 0 {
+		l.Header = o.Header
+	}
+	if len(o.TagColumn) > 0 {
+		l.TagColumn = o.TagColumn
+	}
+	if len(o.ValueColumn) > 0 {
+		l.ValueColumn = o.ValueColumn
+	}
+	if o.FirstRow != 0 {
+		l.FirstRow = o.FirstRow
+	}
+	if o.Rows != 0 {
+		l.Rows = o.Rows
+	}
+	if len(o.UnassignedLane) > 0 {
+		l.UnassignedLane = o.UnassignedLane
+	}
+	if len(o.StartColumn) > 0 {
+		l.StartColumn = o.StartColumn
+	}
+	if o.MaxEntries != 0 {
+		l.MaxEntries = o.MaxEntries
+	}
+	if o.Outline != nil {
+		l.Outline = o.Outline
+	}
+	if len(o.Cell) > 0 {
+		l.Cell = o.Cell
+	}
+	return l
+}
+
+func (c csvConfig) merge(o csvConfig) csvConfig {
+	if len(o.TagColumn) > 0 {
+		c.TagColumn = o.TagColumn
+	}
+	if len(o.HoursColumn) > 0 {
+		c.HoursColumn = o.HoursColumn
+	}
+	if len(o.Delimiter) > 0 {
+		c.Delimiter = o.Delimiter
+	}
+	if len(o.Comment) > 0 {
+		c.Comment = o.Comment
+	}
+	if o.NoHeader != nil {
+		c.NoHeader = o.NoHeader
+	}
+	if len(o.DurationFormat) > 0 {
+		c.DurationFormat = o.DurationFormat
+	}
+	if len(o.Locale) > 0 {
+		c.Locale = o.Locale
+	}
+	return c
+}
+
+// reportSettings are the effective settings of one report run.
+type reportSettings struct {
+	name string
+	reportConfig
+}
+
+// Returns the settings of the report, taking flags over environment
+// variables over the config file.
+func resolveSettings(cmd *cobra.Command, name string) (reportSettings, error) {
+	s, err := settingsFromFlags(cmd, name)
+	if err != nil {
+		return s, err
+	}
+
+	if problems := s.problems(); len(problems) > 0 {
+		return s, fmt.Errorf("Invalid settings for %s:\n  %s", name, strings.Join(problems, "\n  "))
+	}
+	return s, nil
+}
+
+// Returns the settings of the config file with the flags of cmd applied,
+// without checking them.
+func settingsFromFlags(cmd *cobra.Command, name string) (reportSettings, error) {
+	c, err := loadConfig(configPath, rootCmd.PersistentFlags().Changed("config"))
+	if err != nil {
+		return reportSettings{}, err
+	}
+
+	s := settingsFromConfig(c, name)
+
+	if flag := cmd.Flags().Lookup("spreadsheet-id"); flag != nil && flag.Changed {
+		s.Spreadsheet = flag.Value.String()
+	}
+	if flag := cmd.Flags().Lookup("tab-id"); flag != nil && flag.Changed {
+		s.Tab = flag.Value.String()
+	}
+	if flag := cmd.Flags().Lookup("file"); flag != nil && flag.Changed {
+		s.File = flag.Value.String()
+	}
+	if rootCmd.PersistentFlags().Changed("profile") {
+		s.Profile = clientOpts.profile
+	}
+	if flag := cmd.Flags().Lookup("range-name"); flag != nil && flag.Changed {
+		s.Layout.RangeName = flag.Value.String()
+	}
+	if flag := cmd.Flags().Lookup("metadata-key"); flag != nil && flag.Changed {
+		s.Layout.MetadataKey = flag.Value.String()
+	}
+	if cmd.Flags().Changed("header") {
+		s.Layout.Header, _ = cmd.Flags().GetString("header")
+	}
+	if cmd.Flags().Changed("value-column") {
+		s.Layout.ValueColumn, _ = cmd.Flags().GetString("value-column")
+	}
+	if cmd.Flags().Changed("unassigned-lane") {
+		s.Layout.UnassignedLane, _ = cmd.Flags().GetString("unassigned-lane")
+	}
+	if cmd.Flags().Changed("strict") {
+		s.Strict = boolFlag(cmd, "strict")
+	}
+	if cmd.Flags().Changed("tag-column") {
+		s.CSV.TagColumn, _ = cmd.Flags().GetString("tag-column")
+	}
+	if cmd.Flags().Changed("hours-column") {
+		s.CSV.HoursColumn, _ = cmd.Flags().GetString("hours-column")
+	}
+	if cmd.Flags().Changed("delimiter") {
+		s.CSV.Delimiter, _ = cmd.Flags().GetString("delimiter")
+	}
+	if cmd.Flags().Changed("comment") {
+		s.CSV.Comment, _ = cmd.Flags().GetString("comment")
+	}
+	if cmd.Flags().Changed("no-header") {
+		s.CSV.NoHeader = boolFlag(cmd, "no-header")
+	}
+	if cmd.Flags().Changed("duration-format") {
+		s.CSV.DurationFormat, _ = cmd.Flags().GetString("duration-format")
+	}
+	if cmd.Flags().Changed("locale") {
+		s.CSV.Locale, _ = cmd.Flags().GetString("locale")
+	}
+	if cmd.Flags().Changed("input-format") {
+		s.InputFormat, _ = cmd.Flags().GetString("input-format")
+	}
+	if cmd.Flags().Changed("from") {
+		s.From, _ = cmd.Flags().GetString("from")
+	}
+	if cmd.Flags().Changed("to") {
+		s.To, _ = cmd.Flags().GetString("to")
+	}
+	if cmd.Flags().Changed("billable") {
+		s.Billable, _ = cmd.Flags().GetString("billable")
+	}
+	if cmd.Flags().Changed("multi-tag") {
+		s.MultiTag, _ = cmd.Flags().GetString("multi-tag")
+	}
+	if cmd.Flags().Changed("separator") {
+		s.Separator, _ = cmd.Flags().GetString("separator")
+	}
+	if cmd.Flags().Changed("depth") {
+		s.Depth = intFlag(cmd, "depth")
+	}
+	if cmd.Flags().Changed("outline") {
+		s.Layout.Outline = boolFlag(cmd, "outline")
+	}
+	if cmd.Flags().Changed("rules") {
+		s.Rules, _ = cmd.Flags().GetString("rules")
+	}
+	if cmd.Flags().Changed("aliases") {
+		s.Aliases, _ = cmd.Flags().GetString("aliases")
+	}
+	if cmd.Flags().Changed("suggest") {
+		s.Suggest = boolFlag(cmd, "suggest")
+	}
+	if cmd.Flags().Changed("max-entries") {
+		s.Layout.MaxEntries, _ = cmd.Flags().GetInt("max-entries")
+	}
+	if cmd.Flags().Changed("start-column") {
+		s.Layout.StartColumn, _ = cmd.Flags().GetString("start-column")
+	}
+	return s, nil
+}
+
+// Returns the settings of the report from the config file and further file
+// layers over it, e.g. the defaults of a job, with the environment variables
+// applied over all of them.
+func settingsFromConfig(c *fileConfig, name string, layers ...reportConfig) reportSettings {
+	s := reportSettings{
+		name:         name,
+		reportConfig: c.Defaults.merge(c.Reports[name]),
+	}
+	for _, layer := range layers {
+		s.reportConfig = s.reportConfig.merge(layer)
+	}
+
+	// https://docs.google.com/spreadsheets/d/1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms/edit
+	// -> spreadsheetId = 1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms
+	s.Spreadsheet = envOr("SPREADSHEET_ID", s.Spreadsheet)
+	s.Tab = envOr("TAB_ID", s.Tab)
+	s.File = envOr("FILE", s.File)
+
+	if len(s.Profile) < 1 {
+		s.Profile = clientOpts.profile
+	}
+	return s
+}
+
+// Reports whether value is one of values. Empty selects the default and is
+// always accepted.
+func oneOf(value string, values []string) bool {
+	if len(value) < 1 {
+		return true
+	}
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the value of a boolean flag.
+func boolFlag(cmd *cobra.Command, name string) *bool {
+	value, _ := cmd.Flags().GetBool(name)
+	return &value
+}
+
+// Reports whether the optional setting is set and true.
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+// Returns the value of an integer flag.
+func intFlag(cmd *cobra.Command, name string) *int {
+	value, _ := cmd.Flags().GetInt(name)
+	return &value
+}
+
+// Returns the optional setting, 0 if it is not set.
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+// Returns the environment variable, or fallback if it is not set.
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+// Returns every problem with the settings.
+func (s reportSettings) problems() []string {
+	problems := []string{}
+
+	if len(s.Spreadsheet) < 1 {
+		problems = append(problems, "spreadsheet is not set (--spreadsheet-id, SPREADSHEET_ID or config file)")
+	}
+	if len(s.Tab) < 1 {
+		problems = append(problems, "tab is not set (--tab-id, TAB_ID or config file)")
+	}
+	if s.name != reportLastRunTimestamp && len(s.File) < 1 {
+		problems = append(problems, "input file is not set (--file, FILE or config file)")
+	}
+
+	return append(problems, s.reportConfig.problems()...)
+}
+
+// Returns the problems with the settings that are set, whichever report
+// they are for. Settings left empty are not checked.
+func (c reportConfig) problems() []string {
+	problems := []string{}
+
+	if len(c.Profile) > 0 {
+		if _, err := openProfile(c.Profile); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	l := c.Layout
+	checkColumn := func(field, value string) {
+		if len(value) < 1 {
+			return
+		}
+		if _, err := a1.ColumnIndex(value); err != nil {
+			problems = append(problems, fmt.Sprintf("layout.%s: %v", field, err))
+		}
+	}
+	if len(l.RangeName) > 0 && len(l.MetadataKey) > 0 {
+		problems = append(problems, "layout.rangeName and layout.metadataKey exclude each other")
+	}
+	delimiter, err := parseChar(c.CSV.Delimiter)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("csv.delimiter: %v", err))
+	}
+	comment, err := parseChar(c.CSV.Comment)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("csv.comment: %v", err))
+	}
+	if delimiter != 0 && delimiter == comment {
+		problems = append(problems, "csv.delimiter and csv.comment must differ")
+	}
+	if _, err := newDurationParser(c.CSV.DurationFormat, c.CSV.Locale); err != nil {
+		problems = append(problems, fmt.Sprintf("csv: %v", err))
+	}
+	if !oneOf(c.InputFormat, inputFormats) {
+		problems = append(problems, fmt.Sprintf("unknown input format %q, must be one of %s", c.InputFormat, strings.Join(inputFormats, ", ")))
+	} else if isTrue(c.CSV.NoHeader) && isEntryFormat(c.InputFormat) {
+		problems = append(problems, fmt.Sprintf("csv.noHeader: input format %s needs a header row, its columns are found by name", c.InputFormat))
+	}
+	if window, err := parseDateWindow(c.From, c.To); err != nil {
+		problems = append(problems, err.Error())
+	} else if window.isSet() && !isEntryFormat(c.InputFormat) {
+		problems = append(problems, "from and to need time entries, e.g. input format entries")
+	}
+	if !oneOf(c.Billable, billableFilters) {
+		problems = append(problems, fmt.Sprintf("unknown billable filter %q, must be one of %s", c.Billable, strings.Join(billableFilters, ", ")))
+	} else if len(c.Billable) > 0 && c.Billable != billableAll && !isEntryFormat(c.InputFormat) {
+		problems = append(problems, "billable needs time entries, e.g. input format entries")
+	}
+	if !oneOf(c.MultiTag, multiTagPolicies) {
+		problems = append(problems, fmt.Sprintf("unknown multi-tag policy %q, must be one of %s", c.MultiTag, strings.Join(multiTagPolicies, ", ")))
+	}
+	if intValue(c.Depth) < 0 {
+		problems = append(problems, "depth must be positive")
+	}
+	if intValue(c.Depth) > 0 && len(c.Separator) < 1 {
+		problems = append(problems, "depth needs a separator")
+	}
+	if l.FirstRow < 0 {
+		problems = append(problems, "layout.firstRow must be positive")
+	}
+
+	// lane
+	checkColumn("tagColumn", l.TagColumn)
+	checkColumn("valueColumn", l.ValueColumn)
+	if l.Rows < 0 {
+		problems = append(problems, "layout.rows must be positive")
+	}
+	// hours
+	checkColumn("startColumn", l.StartColumn)
+	if l.MaxEntries < 0 {
+		problems = append(problems, "layout.maxEntries must be positive")
+	}
+	if isTrue(l.Outline) && len(c.Separator) < 1 {
+		problems = append(problems, "layout.outline needs a separator")
+	}
+	// last-run-timestamp
+	if len(l.Cell) > 0 {
+		if r, err := a1.Parse(l.Cell); err != nil || len(r.Sheet) > 0 || r.Rows() != 1 || r.Cols() != 1 {
+			problems = append(problems, fmt.Sprintf("layout.cell: %q is not a single cell like D2", l.Cell))
+		}
+	}
+
+	return problems
+}
+
+// Returns the layout fields set for a report that it doesn't use.
+func unusedLayoutFields(name string, l layoutConfig) []string {
+	used := map[string][]string{
+		reportLane:             {"header", "tagColumn", "valueColumn", "firstRow", "rows", "unassignedLane"},
+		reportHours:            {"startColumn", "firstRow", "maxEntries", "outline"},
+		reportLastRunTimestamp: {"cell"},
+	}
+	set := map[string]bool{
+		"header":         len(l.Header) > 0,
+		"tagColumn":      len(l.TagColumn) > 0,
+		"valueColumn":    len(l.ValueColumn) > 0,
+		"firstRow":       l.FirstRow != 0,
+		"rows":           l.Rows != 0,
+		"unassignedLane": len(l.UnassignedLane) > 0,
+		"startColumn":    len(l.StartColumn) > 0,
+		"maxEntries":     l.MaxEntries != 0,
+		"outline":        l.Outline != nil,
+		"cell":           len(l.Cell) > 0,
+	}
+	for _, field := range used[name] {
+		delete(set, field)
+	}
+
+	unused := []string{}
+	for field, isSet := range set {
+		if isSet {
+			unused = append(unused, field)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+// Returns the lane layout. Setting the position of the tags turns off the
+// search for the header.
+func (s reportSettings) laneLayout() laneLayout {
+	layout := defaultLaneLayout()
+	if len(s.Layout.TagColumn) > 0 || s.Layout.FirstRow > 0 || s.Layout.Rows > 0 {
+		layout.header = ""
+	}
+	if len(s.Layout.Header) > 0 {
+		layout.header = s.Layout.Header
+		layout.fallback = false
+	}
+	layout.anchor = s.anchor()
+	if len(s.Layout.TagColumn) > 0 {
+		layout.tagColumn = strings.ToUpper(s.Layout.TagColumn)
+	}
+	if len(s.Layout.ValueColumn) > 0 {
+		layout.valueColumn = strings.ToUpper(s.Layout.ValueColumn)
+	}
+	if s.Layout.FirstRow > 0 {
+		layout.firstRow = s.Layout.FirstRow
+	}
+	if s.Layout.Rows > 0 {
+		layout.rows = s.Layout.Rows
+	}
+	return layout
+}
+
+func (s reportSettings) laneOptions() (laneOptions, error) {
+	matcher, err := loadTagMatcher(s.Aliases)
+	if err != nil {
+		return laneOptions{}, err
+	}
+
+	return laneOptions{
+		unassignedLane: s.Layout.UnassignedLane,
+		strict:         isTrue(s.Strict),
+		matcher:        matcher,
+		suggest:        isTrue(s.Suggest),
+		output:         reportOpts.output,
+	}, nil
+}
+
+func (s reportSettings) hoursLayout() hoursLayout {
+	layout := defaultHoursLayout()
+	layout.anchor = s.anchor()
+	if len(s.Layout.StartColumn) > 0 {
+		layout.startColumn = strings.ToUpper(s.Layout.StartColumn)
+	}
+	if s.Layout.FirstRow > 0 {
+		layout.firstRow = s.Layout.FirstRow
+	}
+	if s.Layout.MaxEntries > 0 {
+		layout.maxEntries = s.Layout.MaxEntries
+	}
+	return layout
+}
+
+func (s reportSettings) anchor() anchor {
+	return anchor{
+		rangeName:   s.Layout.RangeName,
+		metadataKey: s.Layout.MetadataKey,
+	}
+}
+
+// Returns the options reading the input file. The settings are checked by
+// problems.
+func (s reportSettings) csvOptions() csvOptions {
+	delimiter, _ := parseChar(s.CSV.Delimiter)
+	comment, _ := parseChar(s.CSV.Comment)
+	durations, _ := newDurationParser(s.CSV.DurationFormat, s.CSV.Locale)
+	return csvOptions{
+		tagColumn:   s.CSV.TagColumn,
+		hoursColumn: s.CSV.HoursColumn,
+		delimiter:   delimiter,
+		comment:     comment,
+		noHeader:    isTrue(s.CSV.NoHeader),
+		durations:   durations,
+	}
+}
+
+// Returns the options reading the input file. The settings are checked by
+// problems.
+func (s reportSettings) inputOptions() inputOptions {
+	window, _ := parseDateWindow(s.From, s.To)
+	return inputOptions{
+		format:   s.InputFormat,
+		csv:      s.csvOptions(),
+		window:   window,
+		billable: s.Billable,
+		multiTag: s.MultiTag,
+	}
+}
+
+func (s reportSettings) hoursOptions() hoursOptions {
+	return hoursOptions{
+		separator: s.Separator,
+		outline:   isTrue(s.Layout.Outline),
+		output:    reportOpts.output,
+	}
+}
+
+func (s reportSettings) timestampCell() string {
+	if len(s.Layout.Cell) > 0 {
+		return strings.ToUpper(s.Layout.Cell)
+	}
+	return defaultTimestampCell
+}
+
+// Returns a Sheets service authenticated with the profile of the report.
+func (s reportSettings) sheetsService() (*sheets.Service, error) {
+	options := *clientOpts
+	options.profile = s.Profile
+
+	client, err := NewClient(&options)
+	if err != nil {
+		return nil, err
+	}
+	return NewSheetsService(client, &options)
+}
+
+// Adds the flags selecting the spreadsheet, tab and input file.
+func addReportFlags(cmd *cobra.Command, withFile bool) {
+	cmd.Flags().String("spreadsheet-id", "", "Spreadsheet to update (default $SPREADSHEET_ID)")
+	cmd.Flags().String("tab-id", "", "Tab of the spreadsheet to update (default $TAB_ID)")
+	if withFile {
+		addInputFlags(cmd)
+	}
+	cmd.Flags().String("range-name", "", "Named range locating the report instead of its default cells")
+	cmd.Flags().String("metadata-key", "", "Developer metadata key of the rows or columns locating the report")
+}
+
+// Adds the flags selecting the input file and its format.
+func addInputFlags(cmd *cobra.Command) {
+	cmd.Flags().String("file", "", "Input file with hours per tag or time entries, - for stdin (default $FILE)")
+	cmd.Flags().String("input-format", "", "Format of the file: totals, hours per tag, entries, raw time entries, or the export of toggl, clockify, harvest, timew or watson (default totals)")
+	cmd.Flags().String("from", "", "First day of the time entries to sum up, e.g. 2020-06-01")
+	cmd.Flags().String("to", "", "Last day of the time entries to sum up, e.g. 2020-06-30")
+	cmd.Flags().String("billable", "", "Time entries to sum up by their billable flag: all, billable or non-billable (default all)")
+	cmd.Flags().String("multi-tag", "", "Hours of time entries with several tags: split across them or count full for each (default split)")
+	cmd.Flags().String("tag-column", "", "Header or number of the tag column of the file (default 1)")
+	cmd.Flags().String("hours-column", "", "Header or number of the hours column of the file (default 2)")
+	cmd.Flags().String("delimiter", "", "Field delimiter of the file, e.g. ; or tab (default ,)")
+	cmd.Flags().String("comment", "", "Character starting comment lines in the file, e.g. #")
+	cmd.Flags().Bool("no-header", false, "The first row of the file holds data, not the column headers")
+	cmd.Flags().String("duration-format", "", "Format of the hours: auto, decimal, clock, units or iso8601 (default auto)")
+	cmd.Flags().String("locale", "", "Locale of decimal hours, e.g. de for 1,5 or en for 1.5")
+}
+
+// Takes the input file from the arguments instead of --file. "-" reads
+// stdin.
+func setFileArg(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return nil
+	}
+	if cmd.Flags().Changed("file") {
+		return fmt.Errorf("Either --file or a file argument can be given")
+	}
+	return cmd.Flags().Set("file", args[0])
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the config file",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Report every problem with the config file",
+		Long:  `Check the config file, together with the environment variables that override it, and report every problem at once.`,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return validateConfig(configPath, rootCmd.PersistentFlags().Changed("config"))
+		},
+	})
+
+	return cmd
+}
+
+func validateConfig(path string, required bool) error {
+	c, err := loadConfig(path, required)
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+
+	if c.Defaults == (reportConfig{}) && len(c.Reports) == 0 {
+		problems = append(problems, "neither defaults nor reports are set")
+	}
+	// Problems of the defaults are reported once, not for every report
+	// they apply to.
+	inDefaults := map[string]bool{}
+	for _, problem := range c.Defaults.problems() {
+		inDefaults[problem] = true
+		problems = append(problems, fmt.Sprintf("defaults: %s", problem))
+	}
+
+	names := []string{}
+	for name := range c.Reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		known := false
+		for _, reportName := range reportNames {
+			known = known || name == reportName
+		}
+		if !known {
+			problems = append(problems, fmt.Sprintf("reports.%s: unknown report, must be one of %s", name, strings.Join(reportNames, ", ")))
+			continue
+		}
+
+		s := settingsFromConfig(c, name)
+		for _, problem := range s.problems() {
+			if !inDefaults[problem] {
+				problems = append(problems, fmt.Sprintf("reports.%s: %s", name, problem))
+			}
+		}
+		for _, field := range unusedLayoutFields(name, c.Reports[name].Layout) {
+			problems = append(problems, fmt.Sprintf("reports.%s: layout.%s does not apply to this report", name, field))
+		}
+		if len(s.File) > 0 && s.File != "-" {
+			if _, err := os.Stat(s.File); err != nil {
+				problems = append(problems, fmt.Sprintf("reports.%s: input file: %v", name, err))
+			}
+		}
+		if _, err := loadRules(s.Rules); err != nil {
+			problems = append(problems, fmt.Sprintf("reports.%s: %v", name, err))
+		}
+		if _, err := loadTagMatcher(s.Aliases); err != nil {
+			problems = append(problems, fmt.Sprintf("reports.%s: %v", name, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		return fmt.Errorf("%d problem(s) found in %s", len(problems), path)
+	}
+
+	fmt.Printf("%s is valid\n", path)
+	return nil
+}
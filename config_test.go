@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gsheet-updater.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReportConfigMergesFlagsOnlyWhenSet(t *testing.T) {
+	path := writeConfig(t, `
+defaults:
+  strict: true
+  suggest: true
+  separator: ":"
+  depth: 2
+  csv:
+    noHeader: true
+  layout:
+    outline: true
+reports:
+  lane:
+    strict: false
+    depth: 0
+    csv:
+      noHeader: false
+  hours:
+    layout:
+      outline: false
+`)
+	c, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lane := settingsFromConfig(c, reportLane)
+	if isTrue(lane.Strict) || isTrue(lane.CSV.NoHeader) {
+		t.Errorf("lane: strict %v, noHeader %v, want the report's false over the defaults", *lane.Strict, *lane.CSV.NoHeader)
+	}
+	if !isTrue(lane.Suggest) || !isTrue(lane.Layout.Outline) {
+		t.Error("lane: suggest and outline of the defaults are lost")
+	}
+	if intValue(lane.Depth) != 0 {
+		t.Errorf("lane: depth %d, want the report's 0 over the defaults", intValue(lane.Depth))
+	}
+
+	hours := settingsFromConfig(c, reportHours)
+	if isTrue(hours.Layout.Outline) || !isTrue(hours.Strict) || intValue(hours.Depth) != 2 {
+		t.Errorf("hours: outline %v, strict %v, depth %d", *hours.Layout.Outline, *hours.Strict, intValue(hours.Depth))
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     bool
+	}{
+		{name: "valid", content: "defaults:\n  spreadsheet: abc\n  tab: Sprint\nreports:\n  last-run-timestamp:\n    layout:\n      cell: D2\n"},
+		{name: "only defaults", content: "defaults:\n  spreadsheet: abc\n"},
+		{name: "empty", content: "", err: true},
+		{name: "bad defaults", content: "defaults:\n  inputFormat: excel\n", err: true},
+		{name: "bad defaults with reports", content: "defaults:\n  csv:\n    delimiter: ab\nreports:\n  last-run-timestamp:\n    tab: Sprint\n    spreadsheet: abc\n", err: true},
+		{name: "bad report", content: "reports:\n  hours:\n    depth: -1\n", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(writeConfig(t, tt.content), true)
+			if tt.err != (err != nil) {
+				t.Errorf("err = %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateConfigReportsDefaultsOnce(t *testing.T) {
+	path := writeConfig(t, "defaults:\n  spreadsheet: abc\n  tab: Sprint\n  multiTag: some\nreports:\n  last-run-timestamp: {}\n")
+	err := validateConfig(path, true)
+	if err == nil || !strings.HasPrefix(err.Error(), "1 problem(s)") {
+		t.Errorf("err = %v, want the bad default once", err)
+	}
+}
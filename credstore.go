@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// passphraseEnv holds the passphrase of the encrypted credential store. The
+// passphrase is prompted for on a terminal if it is not set.
+const passphraseEnv = "GSHEET_UPDATER_PASSPHRASE"
+
+// sealedFile is the on-disk format of an encrypted secret: a NaCl secretbox
+// keyed with scrypt(passphrase, salt).
+type sealedFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Box     []byte `json:"box"`
+}
+
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	passphraseOnce sync.Once
+	passphrase     []byte
+	passphraseErr  error
+)
+
+// Returns the store passphrase, prompting at most once per run.
+func storePassphrase() ([]byte, error) {
+	passphraseOnce.Do(func() {
+		if env := os.Getenv(passphraseEnv); len(env) > 0 {
+			passphrase = []byte(env)
+			return
+		}
+
+		fd := int(os.Stdin.Fd())
+		if !terminal.IsTerminal(fd) {
+			passphraseErr = fmt.Errorf("Encrypted credentials need a passphrase, set %s", passphraseEnv)
+			return
+		}
+		fmt.Fprint(os.Stderr, "Credential store passphrase: ")
+		passphrase, passphraseErr = terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if passphraseErr == nil && len(passphrase) < 1 {
+			passphraseErr = errors.New("Empty passphrase")
+		}
+	})
+	return passphrase, passphraseErr
+}
+
+func deriveKey(pass, salt []byte) (*[32]byte, error) {
+	k, err := scrypt.Key(pass, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], k)
+	return &key, nil
+}
+
+// Encrypts data and writes it to path.
+func sealFile(path string, data []byte) error {
+	b, err := sealData(data)
+	if err != nil {
+		return err
+	}
+	return writeLocked(path, b)
+}
+
+// Returns data encrypted with the passphrase of the store.
+func sealData(data []byte) ([]byte, error) {
+	pass, err := storePassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := sealedFile{
+		Version: 1,
+		Salt:    make([]byte, 16),
+		Nonce:   make([]byte, 24),
+	}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+
+	key, err := deriveKey(pass, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed.Nonce)
+	sealed.Box = secretbox.Seal(nil, data, &nonce, key)
+
+	return json.Marshal(sealed)
+}
+
+// Reads and decrypts the file at path.
+func openSealedFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sealed sealedFile
+	if err := json.Unmarshal(b, &sealed); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %v", path, err)
+	}
+	if sealed.Version != 1 || len(sealed.Nonce) != 24 {
+		return nil, fmt.Errorf("Unsupported encrypted file %s", path)
+	}
+
+	pass, err := storePassphrase()
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(pass, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed.Nonce)
+
+	data, ok := secretbox.Open(nil, sealed.Box, &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("Unable to decrypt %s, wrong passphrase?", path)
+	}
+	return data, nil
+}
+
+// tokenStore loads and saves the OAuth token of a profile.
+type tokenStore interface {
+	Load() (*oauth2.Token, error)
+	Save(token *oauth2.Token) error
+	Path() string
+	// encode returns the file contents of the token.
+	encode(token *oauth2.Token) ([]byte, error)
+}
+
+type fileTokenStore struct {
+	path string
+}
+
+func (s fileTokenStore) Load() (*oauth2.Token, error) {
+	return tokenFromFile(s.path)
+}
+
+func (s fileTokenStore) Save(token *oauth2.Token) error {
+	return writeToken(s.path, token)
+}
+
+func (s fileTokenStore) Path() string {
+	return s.path
+}
+
+func (s fileTokenStore) encode(token *oauth2.Token) ([]byte, error) {
+	return encodeToken(token)
+}
+
+type encryptedTokenStore struct {
+	path string
+}
+
+func (s encryptedTokenStore) Load() (*oauth2.Token, error) {
+	b, err := openSealedFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := decodeToken(b)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse token in %s: %v", s.path, err)
+	}
+	return tok, nil
+}
+
+func (s encryptedTokenStore) Save(token *oauth2.Token) error {
+	b, err := s.encode(token)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return writeLocked(s.path, b)
+}
+
+func (s encryptedTokenStore) Path() string {
+	return s.path
+}
+
+func (s encryptedTokenStore) encode(token *oauth2.Token) ([]byte, error) {
+	b, err := encodeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return sealData(b)
+}
+
+// Returns whether the profile keeps its secrets in the encrypted store,
+// either because it already holds encrypted files or a passphrase is set.
+func (p profile) encrypted() bool {
+	if len(os.Getenv(passphraseEnv)) > 0 {
+		return true
+	}
+	for _, path := range []string{p.encryptedTokenFile(), p.privateKeyFile()} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p profile) encryptedTokenFile() string {
+	return filepath.Join(p.dir, "token.json.enc")
+}
+
+func (p profile) privateKeyFile() string {
+	return filepath.Join(p.dir, "private-key.enc")
+}
+
+func (p profile) tokenStore() tokenStore {
+	if p.encrypted() {
+		return encryptedTokenStore{path: p.encryptedTokenFile()}
+	}
+	return fileTokenStore{path: p.tokenFile()}
+}
+
+// Returns the service account private key from the encrypted store.
+func (p profile) privateKey() ([]byte, error) {
+	return openSealedFile(p.privateKeyFile())
+}
+
+func newCredsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "creds",
+		Short: "Manage the encrypted credential store",
+		Long:  `Manage the encrypted credential store of a profile. The passphrase is read from ` + passphraseEnv + ` or prompted for.`,
+	}
+
+	cmd.AddCommand(newCredsImportCmd())
+
+	return cmd
+}
+
+func newCredsImportCmd() *cobra.Command {
+	var privateKeyFile string
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Move plaintext token and private key into the encrypted store",
+		Long: `Encrypt the profile's token.json (for the default profile also the legacy token.json in
+the current directory) and the service account private key from --private-key-file or PRIVATE_KEY into the
+credential store. Imported plaintext files are deleted.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return credsImport(clientOpts, privateKeyFile)
+		},
+	}
+
+	cmd.Flags().StringVar(&privateKeyFile, "private-key-file", "", "PEM file with the service account private key")
+
+	return cmd
+}
+
+func credsImport(options *clientOptions, privateKeyFile string) error {
+	p, err := openProfile(options.profile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.dir, 0700); err != nil {
+		return err
+	}
+
+	imported := 0
+
+	paths := []string{p.tokenFile()}
+	if p.name == defaultProfile {
+		// Like getClient, only the default profile uses the token.json in
+		// the current directory.
+		paths = append(paths, "token.json")
+	}
+	for _, path := range paths {
+		tok, err := tokenFromFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read token %s: %v", path, err)
+		}
+
+		store := encryptedTokenStore{path: p.encryptedTokenFile()}
+		if err := store.Save(tok); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		fmt.Printf("Imported token %s into %s\n", path, store.Path())
+		imported++
+		break
+	}
+
+	var privateKey []byte
+	if ref := os.Getenv("PRIVATE_KEY"); len(ref) > 0 {
+		privateKey, err = resolveSecret(context.Background(), ref)
+		if err != nil {
+			return err
+		}
+	}
+	if len(privateKeyFile) > 0 {
+		privateKey, err = ioutil.ReadFile(privateKeyFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read private key: %v", err)
+		}
+	}
+	if len(privateKey) > 0 {
+		if err := sealFile(p.privateKeyFile(), privateKey); err != nil {
+			return err
+		}
+		if len(privateKeyFile) > 0 {
+			if err := os.Remove(privateKeyFile); err != nil {
+				return err
+			}
+			fmt.Printf("Imported private key %s into %s\n", privateKeyFile, p.privateKeyFile())
+		} else {
+			fmt.Printf("Imported private key from PRIVATE_KEY into %s, unset the variable now\n", p.privateKeyFile())
+		}
+		imported++
+	}
+
+	if imported == 0 {
+		return errors.New("Nothing to import: no token.json, --private-key-file or PRIVATE_KEY found")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestGetClientMovesPlaintextTokenIntoEncryptedStore(t *testing.T) {
+	p := testProfile(t)
+	setenv(t, passphraseEnv, "correct horse")
+
+	tok := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	if err := writeToken(p.tokenFile(), tok); err != nil {
+		t.Fatal(err)
+	}
+
+	options := &clientOptions{}
+	config, err := oauthConfig(p, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getClient(config, p, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(p.tokenFile()); !os.IsNotExist(err) {
+		t.Errorf("plaintext token %s is still there: %v", p.tokenFile(), err)
+	}
+	stored, err := encryptedTokenStore{path: p.encryptedTokenFile()}.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RefreshToken != "refresh" {
+		t.Errorf("encrypted token = %+v", stored)
+	}
+}
+
+// Changes into dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestCredsImportLegacyTokenOnlyForDefaultProfile(t *testing.T) {
+	p := testProfile(t)
+	setenv(t, passphraseEnv, "correct horse")
+	chdir(t, t.TempDir())
+
+	tok := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	if err := writeToken("token.json", tok); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := credsImport(&clientOptions{profile: "work"}, ""); err == nil {
+		t.Error("the token.json of the current directory was imported into profile work")
+	}
+	if _, err := os.Stat("token.json"); err != nil {
+		t.Fatalf("token.json is gone: %v", err)
+	}
+
+	if err := credsImport(&clientOptions{profile: defaultProfile}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("token.json"); !os.IsNotExist(err) {
+		t.Errorf("token.json is still there: %v", err)
+	}
+	if _, err := (encryptedTokenStore{path: p.encryptedTokenFile()}).Load(); err != nil {
+		t.Errorf("token not imported into the default profile: %v", err)
+	}
+}
@@ -1,70 +1,249 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 )
 
-func ParseLanesFile(filename string) (map[string]float64, error) {
-	hoursByTag := make(map[string]float64)
+// csvOptions describe the layout of the input file.
+type csvOptions struct {
+	// tagColumn and hoursColumn select a column by its header, or by its
+	// 1-based number. Empty selects the first and second column.
+	tagColumn   string
+	hoursColumn string
+	// delimiter separates the fields, ',' if zero.
+	delimiter rune
+	// comment starts a comment line, none if zero.
+	comment rune
+	// noHeader is set if the first row holds data.
+	noHeader bool
+	// durations parses the hours column.
+	durations durationParser
+}
 
-	csvFile, err := os.Open(filename)
-	if err != nil {
-		return hoursByTag, err
-	}
+type hourTagEntry struct {
+	Hours float64
+	Tag   string
+}
 
-	r := csv.NewReader(csvFile)
-	records, err := r.ReadAll()
+// ParseLanesFile returns the hours per tag. With a separator the segments of
+// hierarchical tags are trimmed, so "a : b" and "a:b" are the same tag.
+func ParseLanesFile(filename string, options inputOptions, separator string) (map[string]float64, error) {
+	hoursByTag := make(map[string]float64)
+
+	entries, err := ParseInputFile(filename, options, separator)
 	if err != nil {
 		return hoursByTag, err
 	}
 
-	for _, record := range records[1:] {
-		v, err := strconv.ParseFloat(record[1], 64)
-		if err != nil {
-			return hoursByTag, err
-		}
-
-		hoursByTag[record[0]] = v
+	for _, entry := range entries {
+		hoursByTag[entry.Tag] += entry.Hours
 	}
 
 	return hoursByTag, nil
 }
 
-type hourTagEntry struct {
-	Hours float64
-	Tag   string
-}
-
-func ParseHoursFile(filename string) ([]hourTagEntry, error) {
+// ParseHoursFile returns the entries of the file. Hierarchical tags are
+// trimmed like in ParseLanesFile. All invalid rows are reported at once.
+func ParseHoursFile(filename string, options csvOptions, separator string) ([]hourTagEntry, error) {
 	ret := make([]hourTagEntry, 0)
 
-	csvFile, err := os.Open(filename)
+	rows, err := readCSV(filename, options)
 	if err != nil {
 		return ret, err
 	}
+	if len(rows) == 0 {
+		return ret, fmt.Errorf("%s is empty", filename)
+	}
 
-	r := csv.NewReader(csvFile)
-	records, err := r.ReadAll()
+	var header []string
+	if !options.noHeader {
+		if rows[0].err != nil {
+			return ret, fmt.Errorf("%s: line %d: %v", filename, rows[0].line, rows[0].err)
+		}
+		header, rows = rows[0].fields, rows[1:]
+	}
+	tagIdx, err := columnIndex(header, options.tagColumn, 0)
 	if err != nil {
-		return ret, err
+		return ret, fmt.Errorf("%s: tag column: %v", filename, err)
 	}
+	hoursIdx, err := columnIndex(header, options.hoursColumn, 1)
+	if err != nil {
+		return ret, fmt.Errorf("%s: hours column: %v", filename, err)
+	}
+
+	problems := []string{}
+	for _, row := range rows {
+		if row.err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", row.line, row.err))
+			continue
+		}
+		if len(row.fields) <= tagIdx || len(row.fields) <= hoursIdx {
+			problems = append(problems, fmt.Sprintf("line %d: expected at least %d fields, got %d", row.line, maxInt(tagIdx, hoursIdx)+1, len(row.fields)))
+			continue
+		}
 
-	for _, record := range records[1:] {
-		v, err := strconv.ParseFloat(record[1], 64)
+		v, err := options.durations.hours(row.fields[hoursIdx])
 		if err != nil {
-			return ret, err
+			problems = append(problems, fmt.Sprintf("line %d: %v", row.line, err))
+			continue
 		}
 
 		entry := hourTagEntry{
-			Tag:   record[0],
+			Tag:   cutTag(row.fields[tagIdx], separator, 0),
 			Hours: v,
 		}
 
 		ret = append(ret, entry)
 	}
 
+	if len(problems) > 0 {
+		return ret, fmt.Errorf("%d invalid row(s) in %s:\n  %s", len(problems), filename, strings.Join(problems, "\n  "))
+	}
 	return ret, nil
+}
+
+// csvRow is a record of the file and the line it starts on.
+type csvRow struct {
+	line   int
+	fields []string
+	err    error
+}
 
+// Reads the records of the file, skipping empty and comment lines. A UTF-8
+// byte order mark is dropped. Records that fail to parse carry the error, so
+// the caller can report all of them.
+func readCSV(filename string, options csvOptions) ([]csvRow, error) {
+	b, err := readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+
+	lr := &lineReader{data: b}
+	r := csv.NewReader(lr)
+	if options.delimiter != 0 {
+		r.Comma = options.delimiter
+	}
+	r.Comment = options.comment
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	rows := []csvRow{}
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		// The reader goes on after the broken record, so all of them are
+		// reported.
+		if pe, ok := err.(*csv.ParseError); ok {
+			rows = append(rows, csvRow{line: pe.StartLine, err: pe.Err})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read %s: %v", filename, err)
+		}
+
+		if len(fields) == 1 && len(strings.TrimSpace(fields[0])) == 0 {
+			continue
+		}
+		// The record ends on the last line read, quoted fields may span
+		// several lines.
+		line := lr.lines
+		for _, field := range fields {
+			line -= strings.Count(field, "\n")
+		}
+		rows = append(rows, csvRow{line: line, fields: append([]string(nil), fields...)})
+	}
+	return rows, nil
+}
+
+// lineReader hands out at most one line per Read. The csv.Reader only reads
+// again once it used up the line before, so lines is the number of the last
+// line of the record it returned.
+type lineReader struct {
+	data    []byte
+	pending []byte
+	lines   int
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	if len(l.pending) == 0 {
+		if len(l.data) == 0 {
+			return 0, io.EOF
+		}
+		end := bytes.IndexByte(l.data, '\n') + 1
+		if end == 0 {
+			end = len(l.data)
+		}
+		l.pending, l.data = l.data[:end], l.data[end:]
+	}
+
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	if len(l.pending) == 0 {
+		l.lines++
+	}
+	return n, nil
+}
+
+// Returns the content of the file, or of stdin if filename is "-".
+func readFile(filename string) ([]byte, error) {
+	if filename == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// Returns the index of the column selected by name, which is a header or a
+// 1-based number. Empty selects def.
+func columnIndex(header []string, name string, def int) (int, error) {
+	if len(name) < 1 {
+		return def, nil
+	}
+	for idx, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+			return idx, nil
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return n - 1, nil
+	}
+	if len(header) == 0 {
+		return 0, fmt.Errorf("%q is not a column number, the file has no header", name)
+	}
+	return 0, fmt.Errorf("no column %q in header %s", name, strings.Join(header, ", "))
+}
+
+// Returns the single character of a delimiter or comment setting. "\t" and
+// "tab" select a tab.
+func parseChar(s string) (rune, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 {
+		return 0, fmt.Errorf("%q is not a single character", s)
+	}
+	return r[0], nil
+}
+
+func maxInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v > m {
+			m = v
+		}
+	}
+	return m
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeInput(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "input.csv")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadCSV(t *testing.T) {
+	path := writeInput(t, "\xef\xbb\xbftag;hours\r\n# comment\r\n\r\n\"multi\nline\";1\r\n   \r\nops;2\r\n")
+
+	rows, err := readCSV(path, csvOptions{delimiter: ';', comment: '#'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []csvRow{
+		{line: 1, fields: []string{"tag", "hours"}},
+		{line: 4, fields: []string{"multi\nline", "1"}},
+		{line: 7, fields: []string{"ops", "2"}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %+v, want %+v", rows, want)
+	}
+}
+
+func TestReadCSVReportsEveryBrokenRecord(t *testing.T) {
+	path := writeInput(t, "tag,hours\ndev,1\nbad\"quote,2\nops,3\nalso \"bad,4\nreview,5\n")
+
+	_, err := ParseHoursFile(path, csvOptions{}, "")
+	if err == nil {
+		t.Fatal("broken records were accepted")
+	}
+	for _, want := range []string{"2 invalid row(s)", "line 3: ", "line 5: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q lacks %q", err, want)
+		}
+	}
+}
+
+func TestReadCSVUnterminatedQuote(t *testing.T) {
+	path := writeInput(t, "tag,hours\ndev,1\n\"open,2\nops,3\n")
+
+	rows, err := readCSV(path, csvOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := rows[len(rows)-1]
+	if last.err == nil || last.line != 3 {
+		t.Errorf("last row = %+v, want the unterminated quote of line 3", last)
+	}
+}
+
+func TestParseHoursFileColumns(t *testing.T) {
+	content := "Date;Project;Duration\n2020-06-01;dev;1.5\n2020-06-02;ops;2\n"
+	want := []hourTagEntry{{Tag: "dev", Hours: 1.5}, {Tag: "ops", Hours: 2}}
+
+	tests := []struct {
+		name    string
+		options csvOptions
+	}{
+		{name: "by header", options: csvOptions{tagColumn: "Project", hoursColumn: "Duration"}},
+		{name: "by header ignoring case and spaces", options: csvOptions{tagColumn: " project", hoursColumn: "DURATION "}},
+		{name: "by number", options: csvOptions{tagColumn: "2", hoursColumn: "3"}},
+	}
+	for _, tt := range tests {
+		tt.options.delimiter = ';'
+		got, err := ParseHoursFile(writeInput(t, content), tt.options, "")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestParseHoursFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		options csvOptions
+		err     string
+	}{
+		{name: "unknown header", content: "tag,hours\ndev,1\n", options: csvOptions{tagColumn: "Project"}, err: `tag column: no column "Project" in header tag, hours`},
+		{name: "name without header", content: "dev,1\n", options: csvOptions{noHeader: true, hoursColumn: "Duration"}, err: "the file has no header"},
+		{name: "empty file", content: "", err: "is empty"},
+		{name: "only blank lines", content: "\n  \n", err: "is empty"},
+		{name: "short rows", content: "tag,hours\ndev,1\nops\nreview,2\n\n", err: "1 invalid row(s)"},
+		{name: "short rows of a selected column", content: "date,tag,hours\n1,dev,1\n2,ops\n3\n", options: csvOptions{tagColumn: "tag", hoursColumn: "hours"}, err: "line 4: expected at least 3 fields, got 1"},
+	}
+	for _, tt := range tests {
+		_, err := ParseHoursFile(writeInput(t, tt.content), tt.options, "")
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	header := []string{"Date", " Project ", "Duration", "2"}
+	tests := []struct {
+		name string
+		def  int
+		want int
+		err  bool
+	}{
+		{name: "", def: 1, want: 1},
+		{name: "project", want: 1},
+		{name: "Duration", want: 2},
+		// A header named like a number wins over the column number.
+		{name: "2", want: 3},
+		{name: "1", want: 0},
+		{name: "0", err: true},
+		{name: "Tag", err: true},
+	}
+	for _, tt := range tests {
+		got, err := columnIndex(header, tt.name, tt.def)
+		if tt.err != (err != nil) || got != tt.want {
+			t.Errorf("columnIndex(%q) = %d, %v, want %d, error %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestReadCSVLineNumbersAfterLongRecord(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	path := writeInput(t, "tag,hours\n\""+long+"\n"+long+"\",1\n# comment\nops,2")
+
+	rows, err := readCSV(path, csvOptions{comment: '#'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1].line != 2 || rows[2].line != 5 {
+		t.Errorf("rows start on lines %d, want 1, 2 and 5", rowLines(rows))
+	}
+}
+
+func rowLines(rows []csvRow) []int {
+	lines := []int{}
+	for _, row := range rows {
+		lines = append(lines, row.line)
+	}
+	return lines
+}
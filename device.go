@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// googleDeviceAuthURL is Google's device authorization endpoint. Device codes
+// are only issued for OAuth clients of type "TVs and Limited Input devices",
+// and only for a few scopes. The spreadsheets scopes are not among them, so
+// Google answers invalid_scope.
+const googleDeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+
+// deviceAuthResponse is the answer of the device authorization endpoint
+// (RFC 8628, section 3.2). Google names the verification field
+// verification_url instead of verification_uri.
+type deviceAuthResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+func (r deviceAuthResponse) verificationURI() string {
+	if r.VerificationURI != "" {
+		return r.VerificationURI
+	}
+	return r.VerificationURL
+}
+
+type deviceTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// deviceFlow is the OAuth 2.0 device authorization grant of an OAuth client.
+type deviceFlow struct {
+	config *oauth2.Config
+	// authURL is the device authorization endpoint.
+	authURL string
+	// out receives the verification URL and user code.
+	out io.Writer
+	// now and sleep time the polling of the token endpoint.
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newDeviceFlow(config *oauth2.Config, out io.Writer) *deviceFlow {
+	return &deviceFlow{
+		config:  config,
+		authURL: googleDeviceAuthURL,
+		out:     out,
+		now:     time.Now,
+		sleep:   sleepContext,
+	}
+}
+
+// Waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// login prints the verification URL and user code and polls the token
+// endpoint of the client until the user approved or denied the request.
+func (f *deviceFlow) login(ctx context.Context) (*oauth2.Token, error) {
+	var auth deviceAuthResponse
+	err := postForm(ctx, f.authURL, url.Values{
+		"client_id": {f.config.ClientID},
+		"scope":     {strings.Join(f.config.Scopes, " ")},
+	}, &auth)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to request device code: %v", err)
+	}
+	if auth.DeviceCode == "" || auth.UserCode == "" {
+		return nil, errors.New("Device authorization response without device or user code")
+	}
+
+	fmt.Fprintf(f.out, "On a device with a browser go to:\n%v\nand enter the code: %v\n", auth.verificationURI(), auth.UserCode)
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiresIn := time.Duration(auth.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 30 * time.Minute
+	}
+	deadline := f.now().Add(expiresIn)
+
+	for {
+		if err := f.sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+		if f.now().After(deadline) {
+			return nil, errors.New("Device code expired before the request was approved")
+		}
+
+		var resp deviceTokenResponse
+		err := postForm(ctx, f.config.Endpoint.TokenURL, url.Values{
+			"client_id":     {f.config.ClientID},
+			"client_secret": {f.config.ClientSecret},
+			"device_code":   {auth.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to poll token endpoint: %v", err)
+		}
+
+		switch resp.Error {
+		case "":
+			tok := &oauth2.Token{
+				AccessToken:  resp.AccessToken,
+				TokenType:    resp.TokenType,
+				RefreshToken: resp.RefreshToken,
+			}
+			if resp.ExpiresIn > 0 {
+				tok.Expiry = f.now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+			}
+			return tok, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return nil, errors.New("Device authorization was denied")
+		case "expired_token":
+			return nil, errors.New("Device code expired before the request was approved")
+		default:
+			return nil, fmt.Errorf("Device authorization failed: %s %s", resp.Error, resp.Description)
+		}
+	}
+}
+
+// Posts a form to endpoint and decodes the JSON answer into v. Error answers
+// of OAuth endpoints are JSON as well and are decoded the same way.
+func postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("unexpected response (%s): %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// fakeDeviceServer answers the device authorization request and then each
+// poll of the token endpoint with the next of polls.
+type fakeDeviceServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	polls  []string
+	polled int
+}
+
+func newFakeDeviceServer(polls ...string) *fakeDeviceServer {
+	s := &fakeDeviceServer{polls: polls}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device/code", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"device_code":"device","user_code":"ABCD-EFGH","verification_url":"https://example.com/device","expires_in":10,"interval":1}`)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		if req.Form.Get("device_code") != "device" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.polled++
+		answer := s.polls[0]
+		if len(s.polls) > 1 {
+			s.polls = s.polls[1:]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if answer == "" {
+			fmt.Fprint(w, `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":%q}`, answer)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// fakeClock is the clock of a device flow. Sleeping advances it at once.
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+// Returns a device flow against srv running on clock.
+func newTestDeviceFlow(srv *fakeDeviceServer, clock *fakeClock, out io.Writer) *deviceFlow {
+	config := &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: srv.URL + "/token"}}
+	flow := newDeviceFlow(config, out)
+	flow.authURL = srv.URL + "/device/code"
+	flow.now = clock.Now
+	flow.sleep = clock.Sleep
+	return flow
+}
+
+func TestDeviceLogin(t *testing.T) {
+	tests := []struct {
+		name  string
+		polls []string
+		err   string
+	}{
+		{name: "success", polls: []string{""}},
+		{name: "authorization pending", polls: []string{"authorization_pending", "authorization_pending", ""}},
+		{name: "slow down", polls: []string{"slow_down", ""}},
+		{name: "expired token", polls: []string{"authorization_pending", "expired_token"}, err: "expired"},
+		{name: "device code expires", polls: []string{"authorization_pending"}, err: "expired"},
+		{name: "access denied", polls: []string{"access_denied"}, err: "denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeDeviceServer(tt.polls...)
+			defer srv.Close()
+
+			clock := &fakeClock{now: time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)}
+			var out strings.Builder
+			tok, err := newTestDeviceFlow(srv, clock, &out).login(context.Background())
+
+			if !strings.Contains(out.String(), "ABCD-EFGH") || !strings.Contains(out.String(), "https://example.com/device") {
+				t.Errorf("output %q lacks the user code or verification URL", out.String())
+			}
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			if tok.AccessToken != "access" || tok.RefreshToken != "refresh" || !tok.Expiry.Equal(clock.now.Add(time.Hour)) {
+				t.Errorf("token = %+v", tok)
+			}
+			if srv.polled != len(tt.polls) {
+				t.Errorf("polled %d times, want %d", srv.polled, len(tt.polls))
+			}
+		})
+	}
+}
+
+func TestDeviceLoginSlowDownIncreasesInterval(t *testing.T) {
+	srv := newFakeDeviceServer("slow_down", "")
+	defer srv.Close()
+
+	clock := &fakeClock{now: time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)}
+	if _, err := newTestDeviceFlow(srv, clock, ioutil.Discard).login(context.Background()); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	// The interval of 1 second grows by 5 seconds after slow_down.
+	want := []time.Duration{time.Second, 6 * time.Second}
+	if !reflect.DeepEqual(clock.slept, want) {
+		t.Errorf("slept %v, want %v", clock.slept, want)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gogolok/gsheet-updater/internal/a1"
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// writePlan is the set of values a report writes, in the order it writes
+// them.
+type writePlan struct {
+	inputOption string
+	data        []*sheets.ValueRange
+	// unmatched are input tags the report has no cell for, their hours go
+	// to the lane unassigned if it is set.
+	unmatched  []unmatchedTag
+	unassigned string
+	// outline are row groups set after the values are written.
+	outline *rowOutline
+}
+
+func (p *writePlan) add(rng string, values [][]interface{}) {
+	p.data = append(p.data, &sheets.ValueRange{
+		Range:  rng,
+		Values: values,
+	})
+}
+
+// cellChange is a planned write of one cell.
+type cellChange struct {
+	Cell string `json:"cell"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+
+	value interface{}
+}
+
+type planDiff struct {
+	Report      string         `json:"report"`
+	Spreadsheet string         `json:"spreadsheet"`
+	Planned     int            `json:"planned"`
+	Changes     []cellChange   `json:"changes"`
+	Unmatched   []unmatchedTag `json:"unmatched,omitempty"`
+	Unassigned  string         `json:"unassigned,omitempty"`
+}
+
+// Returns the diff of a report, planned and changed cells alike.
+func newPlanDiff(report, spreadsheetId string, planned int, changes []cellChange, plan *writePlan) planDiff {
+	return planDiff{
+		Report:      report,
+		Spreadsheet: spreadsheetId,
+		Planned:     planned,
+		Changes:     changes,
+		Unmatched:   plan.unmatched,
+		Unassigned:  plan.unassigned,
+	}
+}
+
+// Reads the current values of every range in the plan and returns the cells
+// the plan would change.
+func diffPlan(srv *sheets.Service, spreadsheetId string, plan *writePlan) ([]cellChange, int, error) {
+	ranges := make([]string, 0, len(plan.data))
+	for _, vr := range plan.data {
+		ranges = append(ranges, vr.Range)
+	}
+
+	resp, err := srv.Spreadsheets.Values.BatchGet(spreadsheetId).Ranges(ranges...).ValueRenderOption("UNFORMATTED_VALUE").Do()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	changes := []cellChange{}
+	planned := 0
+	for idx, vr := range plan.data {
+		r, err := a1.Parse(vr.Range)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var current [][]interface{}
+		if idx < len(resp.ValueRanges) {
+			current = resp.ValueRanges[idx].Values
+		}
+
+		for i, row := range vr.Values {
+			for j, value := range row {
+				if value == nil {
+					// Left unchanged by the write.
+					continue
+				}
+				planned++
+
+				var old interface{}
+				if i < len(current) && j < len(current[i]) {
+					old = current[i][j]
+				}
+				if sameValue(old, value, plan.inputOption) {
+					continue
+				}
+
+				changes = append(changes, cellChange{
+					Cell:  r.Offset(i, j).String(),
+					Old:   cellString(old),
+					New:   cellString(value),
+					value: value,
+				})
+			}
+		}
+	}
+
+	return changes, planned, nil
+}
+
+// Reports whether writing value to a cell holding the unformatted value old
+// changes it. Numbers compare by value, so "3.00" entered by the user equals
+// a cell holding 3.
+func sameValue(old, value interface{}, inputOption string) bool {
+	if inputOption == "USER_ENTERED" {
+		oldNumber, oldIsNumber := numberValue(old)
+		newNumber, newIsNumber := numberValue(value)
+		if oldIsNumber && newIsNumber {
+			return oldNumber == newNumber
+		}
+	} else if number, ok := value.(float64); ok {
+		if oldNumber, ok := old.(float64); ok {
+			return oldNumber == number
+		}
+	}
+	return cellString(old) == cellString(value)
+}
+
+// Returns the number a value stands for, parsing strings like the API does
+// for user entered input.
+func numberValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Writes the cells of the plan that differ from the sheet in a single
+// request. Nothing is sent if no cell changes. Returns the changed cells.
+func applyPlan(srv *sheets.Service, spreadsheetId string, plan *writePlan) ([]cellChange, error) {
+	changes, _, err := diffPlan(srv, spreadsheetId, plan)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	rb := &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: plan.inputOption,
+	}
+	for _, change := range changes {
+		rb.Data = append(rb.Data, &sheets.ValueRange{
+			Range:  change.Cell,
+			Values: [][]interface{}{{change.value}},
+		})
+	}
+
+	_, err = srv.Spreadsheets.Values.BatchUpdate(spreadsheetId, rb).Do()
+	return changes, err
+}
+
+// Returns how a value is shown in a cell.
+func cellString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Plans the report and prints the changes it would make without writing.
+func dryRun(rep report, settings reportSettings, srv *sheets.Service, output string, out io.Writer) error {
+	plan, err := rep.Plan()
+	if err != nil {
+		return err
+	}
+
+	changes, planned, err := diffPlan(srv, settings.Spreadsheet, plan)
+	if err != nil {
+		return err
+	}
+
+	diff := newPlanDiff(settings.name, settings.Spreadsheet, planned, changes, plan)
+	if output == outputJSON {
+		return json.NewEncoder(out).Encode(diff)
+	}
+	return printDiff(out, diff)
+}
+
+func printDiff(out io.Writer, diff planDiff) error {
+	fmt.Fprintf(out, "Dry run of %s: %d of %d planned cells change\n", diff.Report, len(diff.Changes), diff.Planned)
+	if len(diff.Changes) > 0 {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CELL\tOLD\tNEW")
+		for _, change := range diff.Changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", change.Cell, change.Old, change.New)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return printUnmatched(out, diff.Unmatched, diff.Unassigned)
+}
+
+// Prints the input tags without a lane and where their hours went.
+func printUnmatched(out io.Writer, unmatched []unmatchedTag, unassigned string) error {
+	if len(unmatched) == 0 {
+		return nil
+	}
+
+	total := 0.0
+	for _, u := range unmatched {
+		total += u.Hours
+	}
+	if len(unassigned) > 0 {
+		fmt.Fprintf(out, "%d tag(s) without a lane, %v hours added to %s:\n", len(unmatched), total, unassigned)
+	} else {
+		fmt.Fprintf(out, "%d tag(s) without a lane, %v hours not written:\n", len(unmatched), total)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, u := range unmatched {
+		if len(u.Suggestion) > 0 {
+			fmt.Fprintf(w, "  %s\t%v\tdid you mean %s?\n", u.Tag, u.Hours, u.Suggestion)
+			continue
+		}
+		fmt.Fprintf(w, "  %s\t%v\n", u.Tag, u.Hours)
+	}
+	return w.Flush()
+}
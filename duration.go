@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the hours in the input file.
+const (
+	// durationAuto detects the format of every value.
+	durationAuto = "auto"
+	// durationDecimal are decimal hours like 1.5 or 1,5.
+	durationDecimal = "decimal"
+	// durationClock are hours and minutes like 1:30 or 01:30:00.
+	durationClock = "clock"
+	// durationUnits are durations with units like 1h30m or 90m.
+	durationUnits = "units"
+	// durationISO8601 are ISO 8601 durations like PT1H30M.
+	durationISO8601 = "iso8601"
+)
+
+var durationFormats = []string{durationAuto, durationDecimal, durationClock, durationUnits, durationISO8601}
+
+// decimalCommaLanguages write decimal numbers with a comma, like 1,5.
+var decimalCommaLanguages = map[string]bool{
+	"bg": true, "cs": true, "da": true, "de": true, "el": true, "es": true,
+	"et": true, "fi": true, "fr": true, "hr": true, "hu": true, "id": true,
+	"it": true, "lt": true, "lv": true, "nb": true, "nl": true, "nn": true,
+	"no": true, "pl": true, "pt": true, "ro": true, "ru": true, "sk": true,
+	"sl": true, "sr": true, "sv": true, "tr": true, "uk": true,
+}
+
+var (
+	decimalPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+	clockPattern   = regexp.MustCompile(`^(\d+):([0-5]\d)(?::([0-5]\d))?$`)
+	// groupedPattern could be decimal hours as well as a thousands group.
+	groupedPattern = regexp.MustCompile(`^[+-]?[1-9]\d{0,2}[.,]\d{3}$`)
+	iso8601Pattern = regexp.MustCompile(`^P(?:(\d+(?:[.,]\d+)?)D)?(?:T(?:(\d+(?:[.,]\d+)?)H)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+)
+
+// durationParser turns the durations of the input file into hours. The zero
+// value detects the format and takes a dot as decimal separator.
+type durationParser struct {
+	format string
+	// decimal and thousands are the separators of the locale, zero if no
+	// locale is set.
+	decimal   rune
+	thousands rune
+}
+
+// Returns a parser for the format, one of durationFormats, and the locale,
+// e.g. "de" or "en_US". Both may be empty.
+func newDurationParser(format, locale string) (durationParser, error) {
+	p := durationParser{format: format}
+	if len(format) < 1 {
+		p.format = durationAuto
+	}
+
+	known := false
+	for _, f := range durationFormats {
+		known = known || p.format == f
+	}
+	if !known {
+		return p, fmt.Errorf("unknown duration format %q, must be one of %s", format, strings.Join(durationFormats, ", "))
+	}
+
+	if len(locale) > 0 {
+		language := strings.ToLower(locale)
+		if idx := strings.IndexAny(language, "-_."); idx >= 0 {
+			language = language[:idx]
+		}
+		if len(language) != 2 && len(language) != 3 {
+			return p, fmt.Errorf("invalid locale %q, expected a language like de or en_US", locale)
+		}
+		p.decimal, p.thousands = '.', ','
+		if decimalCommaLanguages[language] {
+			p.decimal, p.thousands = ',', '.'
+		}
+	}
+	return p, nil
+}
+
+// Returns the hours of a duration.
+func (p durationParser) hours(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 1 {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	format := p.format
+	if len(format) < 1 || format == durationAuto {
+		format = detectDurationFormat(s)
+	}
+
+	var hours float64
+	var err error
+	switch format {
+	case durationDecimal:
+		hours, err = p.decimalHours(s)
+	case durationClock:
+		hours, err = clockHours(s)
+	case durationUnits:
+		hours, err = unitHours(s)
+	case durationISO8601:
+		hours, err = iso8601Hours(s)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Negative decimal hours correct the hours of other rows, like -0.5.
+	if hours < 0 && format != durationDecimal {
+		return 0, fmt.Errorf("negative duration %q", s)
+	}
+	return hours, nil
+}
+
+func detectDurationFormat(s string) string {
+	switch {
+	case decimalPattern.MatchString(s):
+		// Before looking for units, 1e3 is a number.
+		return durationDecimal
+	case strings.HasPrefix(strings.ToUpper(s), "P"):
+		return durationISO8601
+	case strings.Contains(s, ":"):
+		return durationClock
+	case strings.IndexFunc(s, func(r rune) bool { return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' }) >= 0:
+		return durationUnits
+	default:
+		return durationDecimal
+	}
+}
+
+// Parses decimal hours. Without a locale a dot or a comma is taken as decimal
+// separator unless it could as well separate thousands, like in 1.500 or
+// 1,500. 0.125 is never a thousands group.
+func (p durationParser) decimalHours(s string) (float64, error) {
+	decimal, thousands := p.decimal, p.thousands
+	if decimal == 0 {
+		hasDot, hasComma := strings.ContainsRune(s, '.'), strings.ContainsRune(s, ',')
+		switch {
+		case hasDot && hasComma:
+			return 0, fmt.Errorf("ambiguous duration %q, set a locale to tell decimal and thousands separators apart", s)
+		case groupedPattern.MatchString(s):
+			return 0, fmt.Errorf("ambiguous duration %q, set a locale to tell whether %q separates decimals or thousands", s, s[strings.IndexAny(s, ".,")])
+		case hasComma:
+			decimal = ','
+		default:
+			decimal = '.'
+		}
+	}
+
+	normalized := s
+	if thousands != 0 && strings.ContainsRune(s, thousands) {
+		integer := strings.SplitN(s, string(decimal), 2)[0]
+		for idx, group := range strings.Split(integer, string(thousands)) {
+			if idx > 0 && len(group) != 3 || idx == 0 && (len(group) < 1 || len(group) > 3) {
+				return 0, fmt.Errorf("invalid duration %q, %q separates thousands", s, thousands)
+			}
+		}
+		normalized = strings.Replace(normalized, string(thousands), "", -1)
+	}
+	if decimal != '.' && strings.ContainsRune(normalized, '.') {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	normalized = strings.Replace(normalized, string(decimal), ".", -1)
+	if !decimalPattern.MatchString(normalized) {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return strconv.ParseFloat(normalized, 64)
+}
+
+// Parses hours and minutes like 1:30, optionally with seconds.
+func clockHours(s string) (float64, error) {
+	m := clockPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q, expected h:mm or h:mm:ss", s)
+	}
+	h, _ := strconv.Atoi(m[1])
+	minutes, _ := strconv.Atoi(m[2])
+	sec := 0
+	if len(m[3]) > 0 {
+		sec, _ = strconv.Atoi(m[3])
+	}
+	return float64(h) + float64(minutes)/60 + float64(sec)/3600, nil
+}
+
+// Parses durations with units like 1h30m, 90m or 1.5h.
+func unitHours(s string) (float64, error) {
+	d, err := time.ParseDuration(strings.Replace(strings.ToLower(s), " ", "", -1))
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, expected units like 1h30m", s)
+	}
+	return d.Hours(), nil
+}
+
+// Parses ISO 8601 durations like PT1H30M. A day counts 24 hours.
+func iso8601Hours(s string) (float64, error) {
+	m := iso8601Pattern.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil || s == "P" || strings.HasSuffix(strings.ToUpper(s), "T") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+
+	hours := 0.0
+	for idx, factor := range []float64{24, 1, 1.0 / 60, 1.0 / 3600} {
+		if len(m[idx+1]) < 1 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.Replace(m[idx+1], ",", ".", 1), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+		}
+		hours += v * factor
+	}
+	return hours, nil
+}
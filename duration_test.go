@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestDurationParserHours(t *testing.T) {
+	tests := []struct {
+		format string
+		locale string
+		in     string
+		want   float64
+		err    bool
+	}{
+		{in: "1.5", want: 1.5},
+		{in: "1,5", want: 1.5},
+		{in: "-0.5", want: -0.5},
+		{in: "+2", want: 2},
+		{in: "1.5e1", want: 15},
+		{in: "1E-1", want: 0.1},
+		{in: ".25", want: 0.25},
+		{in: "1:30", want: 1.5},
+		{in: "01:30:00", want: 1.5},
+		{in: "1h30m", want: 1.5},
+		{in: "90m", want: 1.5},
+		{in: "PT1H30M", want: 1.5},
+		{in: "P1D", want: 24},
+		{in: "-1h", err: true},
+		{in: "1,500", err: true},
+		{in: "1.500", err: true},
+		{in: "-2.250", err: true},
+		{in: "0.125", want: 0.125},
+		{in: "0,125", want: 0.125},
+		{in: "1.5000", want: 1.5},
+		{in: "1,25", want: 1.25},
+		{locale: "en", in: "1.500", want: 1.5},
+		{locale: "de", in: "1.500", want: 1500},
+		{locale: "de", in: "1,500", want: 1.5},
+		{in: "1.234,5", err: true},
+		{in: "abc", err: true},
+		{in: "", err: true},
+		{locale: "de", in: "1.234,5", want: 1234.5},
+		{locale: "de", in: "-0,5", want: -0.5},
+		{locale: "en", in: "1,500", want: 1500},
+		{locale: "en", in: "1,5", err: true},
+		{format: durationDecimal, in: "-1.5", want: -1.5},
+		{format: durationDecimal, in: "1:30", err: true},
+		{format: durationClock, in: "1.5", err: true},
+	}
+	for _, tt := range tests {
+		p, err := newDurationParser(tt.format, tt.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.hours(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s/%s %q = %v, want an error", tt.format, tt.locale, tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s/%s %q = %v, %v, want %v", tt.format, tt.locale, tt.in, got, err, tt.want)
+		}
+	}
+}
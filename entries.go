@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Formats of the input file.
+const (
+	// inputTotals are hours per tag, summed up before.
+	inputTotals = "totals"
+	// inputEntries are raw time entries, summed up per tag by the tool.
+	inputEntries = "entries"
+)
+
+var inputFormats = []string{inputTotals, inputEntries, inputToggl, inputClockify, inputHarvest, inputTimew, inputWatson}
+
+// timestampLayouts are the accepted start and end times of time entries.
+// Times without a zone are local.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+const dateLayout = "2006-01-02"
+
+// timeEntry is a single block of tracked time.
+type timeEntry struct {
+	Start time.Time
+	// End is zero if the input only has a duration.
+	End   time.Time
+	Hours float64
+	// Tags has at least one tag, which may be empty.
+	Tags        []string
+	Person      string
+	Description string
+	Billable    bool
+}
+
+// dateWindow selects entries by the day they start on. Zero bounds are open.
+type dateWindow struct {
+	from time.Time
+	// to is the start of the day after the last day of the window.
+	to time.Time
+}
+
+// Returns the window of the days from and to, both inclusive and formatted
+// like 2006-01-02. Either may be empty.
+func parseDateWindow(from, to string) (dateWindow, error) {
+	w := dateWindow{}
+	if len(from) > 0 {
+		t, err := time.ParseInLocation(dateLayout, from, time.Local)
+		if err != nil {
+			return w, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", from)
+		}
+		w.from = t
+	}
+	if len(to) > 0 {
+		t, err := time.ParseInLocation(dateLayout, to, time.Local)
+		if err != nil {
+			return w, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", to)
+		}
+		w.to = t.AddDate(0, 0, 1)
+	}
+	if !w.from.IsZero() && !w.to.IsZero() && !w.from.Before(w.to) {
+		return w, fmt.Errorf("from date %s is after to date %s", from, to)
+	}
+	return w, nil
+}
+
+func (w dateWindow) isSet() bool {
+	return !w.from.IsZero() || !w.to.IsZero()
+}
+
+func (w dateWindow) contains(t time.Time) bool {
+	if !w.from.IsZero() && t.Before(w.from) {
+		return false
+	}
+	return w.to.IsZero() || t.Before(w.to)
+}
+
+// inputOptions describe the format of the input file and the entries to
+// take from it.
+type inputOptions struct {
+	// format is one of inputFormats, inputTotals if empty.
+	format string
+	csv    csvOptions
+	window dateWindow
+	// billable is one of billableFilters, all entries if empty.
+	billable string
+	// multiTag is one of multiTagPolicies, multiTagSplit if empty.
+	multiTag string
+}
+
+// Reports whether the input format has time entries, not hours per tag.
+func isEntryFormat(format string) bool {
+	return len(format) > 0 && format != inputTotals
+}
+
+// ParseInputFile returns the hours per tag of the input file in the order
+// the tags first appear. Hierarchical tags are trimmed like in
+// ParseLanesFile. A filename of "-" reads stdin.
+func ParseInputFile(filename string, options inputOptions, separator string) ([]hourTagEntry, error) {
+	var entries []timeEntry
+	var err error
+	switch options.format {
+	case "", inputTotals:
+		return ParseHoursFile(filename, options.csv, separator)
+	case inputEntries:
+		entries, err = ParseEntriesFile(filename, options.csv)
+	case inputToggl, inputClockify, inputHarvest:
+		entries, err = parseTrackerExport(filename, trackerExports[options.format], options.csv)
+	case inputTimew:
+		entries, err = parseTimewExport(filename, time.Now())
+	case inputWatson:
+		entries, err = parseWatsonFrames(filename)
+	default:
+		return nil, fmt.Errorf("unknown input format %q", options.format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sumEntries(filterEntries(entries, options.window, options.billable), separator, options.multiTag), nil
+}
+
+// ParseEntriesFile returns the time entries of a CSV file. Its columns are
+// found by the headers start, end, duration (or hours), tag, person,
+// description and billable. tagColumn and hoursColumn of the options select
+// other tag and duration columns. Each entry needs a start, and a duration or
+// an end.
+func ParseEntriesFile(filename string, options csvOptions) ([]timeEntry, error) {
+	ret := make([]timeEntry, 0)
+
+	rows, err := readCSV(filename, options)
+	if err != nil {
+		return ret, err
+	}
+	if len(rows) == 0 {
+		return ret, fmt.Errorf("%s is empty", filename)
+	}
+
+	if options.noHeader {
+		return ret, fmt.Errorf("%s: time entries need a header row, their columns are found by name", filename)
+	}
+	if rows[0].err != nil {
+		return ret, fmt.Errorf("%s: line %d: %v", filename, rows[0].line, rows[0].err)
+	}
+	header, rows := rows[0].fields, rows[1:]
+
+	startIdx, err := columnIndex(header, "start", 0)
+	if err != nil {
+		return ret, fmt.Errorf("%s: start column: %v", filename, err)
+	}
+	tagIdx, err := columnIndex(header, firstNonEmpty(options.tagColumn, "tag"), 0)
+	if err != nil {
+		return ret, fmt.Errorf("%s: tag column: %v", filename, err)
+	}
+	durationIdx := optionalColumnIndex(header, "duration", "hours")
+	if len(options.hoursColumn) > 0 {
+		durationIdx, err = columnIndex(header, options.hoursColumn, 0)
+		if err != nil {
+			return ret, fmt.Errorf("%s: duration column: %v", filename, err)
+		}
+	}
+	endIdx := optionalColumnIndex(header, "end")
+	if durationIdx < 0 && endIdx < 0 {
+		return ret, fmt.Errorf("%s: either a duration or an end column is needed", filename)
+	}
+	personIdx := optionalColumnIndex(header, "person")
+	descriptionIdx := optionalColumnIndex(header, "description")
+	billableIdx := optionalColumnIndex(header, "billable")
+
+	problems := []string{}
+	for _, row := range rows {
+		if row.err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", row.line, row.err))
+			continue
+		}
+
+		entry, err := parseEntry(row.fields, options.durations, startIdx, endIdx, durationIdx)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", row.line, err))
+			continue
+		}
+		entry.Tags = []string{fieldAt(row.fields, tagIdx)}
+		entry.Person = fieldAt(row.fields, personIdx)
+		entry.Description = fieldAt(row.fields, descriptionIdx)
+		entry.Billable, err = parseBillable(fieldAt(row.fields, billableIdx))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", row.line, err))
+			continue
+		}
+
+		ret = append(ret, entry)
+	}
+
+	if len(problems) > 0 {
+		return ret, fmt.Errorf("%d invalid row(s) in %s:\n  %s", len(problems), filename, strings.Join(problems, "\n  "))
+	}
+	return ret, nil
+}
+
+// Returns the times and hours of an entry. A duration wins over the time
+// between start and end.
+func parseEntry(fields []string, durations durationParser, startIdx, endIdx, durationIdx int) (timeEntry, error) {
+	entry := timeEntry{}
+
+	start := fieldAt(fields, startIdx)
+	if len(start) < 1 {
+		return entry, fmt.Errorf("start is empty")
+	}
+	var err error
+	if entry.Start, err = parseTimestamp(start); err != nil {
+		return entry, err
+	}
+
+	if end := fieldAt(fields, endIdx); len(end) > 0 {
+		if entry.End, err = parseTimestamp(end); err != nil {
+			return entry, err
+		}
+		if entry.End.Before(entry.Start) {
+			return entry, fmt.Errorf("end %s is before start %s", end, start)
+		}
+	}
+
+	if duration := fieldAt(fields, durationIdx); len(duration) > 0 {
+		entry.Hours, err = durations.hours(duration)
+		return entry, err
+	}
+	if entry.End.IsZero() {
+		return entry, fmt.Errorf("neither duration nor end is set")
+	}
+	entry.Hours = entry.End.Sub(entry.Start).Hours()
+	return entry, nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. 2006-01-02 15:04 or RFC 3339", s)
+}
+
+// Returns the entries starting within the window that pass the billable
+// filter.
+func filterEntries(entries []timeEntry, window dateWindow, billable string) []timeEntry {
+	ret := make([]timeEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !window.contains(entry.Start) {
+			continue
+		}
+		if billable == billableOnly && !entry.Billable || billable == billableNonBillable && entry.Billable {
+			continue
+		}
+		ret = append(ret, entry)
+	}
+	return ret
+}
+
+// Returns the hours summed per tag, in the order the tags first appear. The
+// hours of entries with several tags are split or counted fully for each
+// tag, by the multiTag policy.
+func sumEntries(entries []timeEntry, separator, multiTag string) []hourTagEntry {
+	ret := make([]hourTagEntry, 0, len(entries))
+	index := map[string]int{}
+	for _, entry := range entries {
+		hours := entry.Hours
+		if multiTag != multiTagFull && len(entry.Tags) > 1 {
+			hours /= float64(len(entry.Tags))
+		}
+
+		for _, tag := range entry.Tags {
+			tag = cutTag(tag, separator, 0)
+			if idx, ok := index[tag]; ok {
+				ret[idx].Hours += hours
+				continue
+			}
+			index[tag] = len(ret)
+			ret = append(ret, hourTagEntry{Tag: tag, Hours: hours})
+		}
+	}
+	return ret
+}
+
+// Returns the index of the first of the columns found in the header, or -1.
+func optionalColumnIndex(header []string, names ...string) int {
+	for _, name := range names {
+		for idx, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+// Returns the trimmed field at idx, empty if the row is shorter.
+func fieldAt(fields []string, idx int) string {
+	if idx < 0 || idx >= len(fields) {
+		return ""
+	}
+	return strings.TrimSpace(fields[idx])
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEntriesFileRejectsNoHeader(t *testing.T) {
+	path := writeInput(t, "2020-06-01 09:00,2020-06-01 10:30,,dev\n")
+
+	_, err := ParseEntriesFile(path, csvOptions{noHeader: true})
+	if err == nil || !strings.Contains(err.Error(), "need a header row") {
+		t.Errorf("err = %v, want the header row asked for", err)
+	}
+
+	noHeader := true
+	problems := reportConfig{InputFormat: inputEntries, CSV: csvConfig{NoHeader: &noHeader}}.problems()
+	if len(problems) != 1 || !strings.Contains(problems[0], "csv.noHeader") {
+		t.Errorf("problems = %v, want csv.noHeader rejected", problems)
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		fields []string
+		hours  float64
+		end    time.Time
+		err    string
+	}{
+		{name: "end", fields: []string{"2020-06-01 09:00", "2020-06-01 10:30", ""}, hours: 1.5, end: start.Add(90 * time.Minute)},
+		{name: "duration", fields: []string{"2020-06-01 09:00", "", "0:45"}, hours: 0.75},
+		{name: "duration wins over end", fields: []string{"2020-06-01 09:00", "2020-06-01 10:30", "2"}, hours: 2, end: start.Add(90 * time.Minute)},
+		{name: "short row", fields: []string{"2020-06-01T09:00"}, err: "neither duration nor end is set"},
+		{name: "neither set", fields: []string{"2020-06-01 09:00", " ", ""}, err: "neither duration nor end is set"},
+		{name: "end before start", fields: []string{"2020-06-01 09:00", "2020-06-01 08:00", "1"}, err: "is before start"},
+		{name: "no start", fields: []string{"", "2020-06-01 10:30", "1"}, err: "start is empty"},
+		{name: "invalid start", fields: []string{"June 1st", "", "1"}, err: "invalid time"},
+		{name: "invalid duration", fields: []string{"2020-06-01 09:00", "", "long"}, err: "invalid duration"},
+	}
+	for _, tt := range tests {
+		got, err := parseEntry(tt.fields, durationParser{}, 0, 1, 2)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !got.Start.Equal(start) || !got.End.Equal(tt.end) || got.Hours != tt.hours {
+			t.Errorf("%s: got %v to %v, %v hours, want %v to %v, %v hours", tt.name, got.Start, got.End, got.Hours, start, tt.end, tt.hours)
+		}
+	}
+}
+
+func TestParseDateWindow(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 6, d, 0, 0, 0, 0, time.Local) }
+	tests := []struct {
+		from, to string
+		want     dateWindow
+		err      bool
+	}{
+		{want: dateWindow{}},
+		{from: "2020-06-01", want: dateWindow{from: day(1)}},
+		// The to day is inclusive, the window ends with the day after.
+		{to: "2020-06-30", want: dateWindow{to: day(31)}},
+		{from: "2020-06-01", to: "2020-06-01", want: dateWindow{from: day(1), to: day(2)}},
+		{from: "2020-06-02", to: "2020-06-01", err: true},
+		{from: "06/01/2020", err: true},
+		{to: "2020-06-31", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDateWindow(tt.from, tt.to)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseDateWindow(%q, %q) = %+v, want an error", tt.from, tt.to, got)
+			}
+			continue
+		}
+		if err != nil || !got.from.Equal(tt.want.from) || !got.to.Equal(tt.want.to) {
+			t.Errorf("parseDateWindow(%q, %q) = %+v, %v, want %+v", tt.from, tt.to, got, err, tt.want)
+		}
+	}
+
+	// The last second of the to day is in the window.
+	w, _ := parseDateWindow("2020-06-01", "2020-06-01")
+	if !w.contains(day(2).Add(-time.Second)) || w.contains(day(2)) {
+		t.Errorf("window %+v doesn't end with 2020-06-01", w)
+	}
+}
+
+func TestFilterEntries(t *testing.T) {
+	at := func(d int) time.Time { return time.Date(2020, 6, d, 12, 0, 0, 0, time.Local) }
+	entries := []timeEntry{
+		{Start: at(1), Tags: []string{"early"}, Billable: true},
+		{Start: at(2), Tags: []string{"billable"}, Billable: true},
+		{Start: at(2), Tags: []string{"internal"}},
+		{Start: at(3), Tags: []string{"late"}, Billable: true},
+	}
+	window, err := parseDateWindow("2020-06-02", "2020-06-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		window   dateWindow
+		billable string
+		want     []string
+	}{
+		{name: "everything", want: []string{"early", "billable", "internal", "late"}},
+		{name: "window", window: window, want: []string{"billable", "internal"}},
+		{name: "window and all", window: window, billable: billableAll, want: []string{"billable", "internal"}},
+		{name: "window and billable", window: window, billable: billableOnly, want: []string{"billable"}},
+		{name: "window and non-billable", window: window, billable: billableNonBillable, want: []string{"internal"}},
+		{name: "billable", billable: billableOnly, want: []string{"early", "billable", "late"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, entry := range filterEntries(entries, tt.window, tt.billable) {
+			got = append(got, entry.Tags[0])
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSumEntries(t *testing.T) {
+	entries := []timeEntry{
+		{Hours: 1, Tags: []string{"review"}},
+		{Hours: 2, Tags: []string{"dev", "review"}},
+		{Hours: 1.5, Tags: []string{"ops"}},
+		{Hours: 3, Tags: []string{"ops", "dev", "review"}},
+		{Hours: 0.5, Tags: []string{"dev"}},
+	}
+
+	tests := []struct {
+		multiTag string
+		want     []hourTagEntry
+	}{
+		{multiTag: "", want: []hourTagEntry{{Tag: "review", Hours: 3}, {Tag: "dev", Hours: 2.5}, {Tag: "ops", Hours: 2.5}}},
+		{multiTag: multiTagSplit, want: []hourTagEntry{{Tag: "review", Hours: 3}, {Tag: "dev", Hours: 2.5}, {Tag: "ops", Hours: 2.5}}},
+		{multiTag: multiTagFull, want: []hourTagEntry{{Tag: "review", Hours: 6}, {Tag: "dev", Hours: 5.5}, {Tag: "ops", Hours: 4.5}}},
+	}
+	for _, tt := range tests {
+		got := sumEntries(entries, "", tt.multiTag)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("multiTag %q: got %v, want %v", tt.multiTag, got, tt.want)
+		}
+	}
+}
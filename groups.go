@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// rowGroup is a collapsible group of rows, zero based with end exclusive.
+type rowGroup struct {
+	start int
+	end   int
+}
+
+// rowOutline are the row groups a report wants within the rows [start, end)
+// of a sheet.
+type rowOutline struct {
+	sheet  string
+	start  int
+	end    int
+	groups []rowGroup
+}
+
+// Replaces the row groups within the block of the outline with its groups in
+// a single request. Nothing is sent if the sheet already has these groups.
+func syncRowGroups(srv *sheets.Service, spreadsheetId string, outline *rowOutline) error {
+	ss, err := srv.Spreadsheets.Get(spreadsheetId).Fields("sheets.properties", "sheets.rowGroups").Do()
+	if err != nil {
+		return err
+	}
+
+	var sheet *sheets.Sheet
+	for _, sh := range ss.Sheets {
+		if sh.Properties.Title == outline.sheet {
+			sheet = sh
+		}
+	}
+	if sheet == nil {
+		return fmt.Errorf("No sheet %s in spreadsheet %s.", outline.sheet, spreadsheetId)
+	}
+
+	existing := []*sheets.DimensionGroup{}
+	for _, g := range sheet.RowGroups {
+		if g.Range.StartIndex >= int64(outline.start) && g.Range.EndIndex <= int64(outline.end) {
+			existing = append(existing, g)
+		}
+	}
+	if sameGroups(existing, outline.groups) {
+		return nil
+	}
+
+	requests := []*sheets.Request{}
+	// Inner groups first, deleting a group lowers the depth of the groups
+	// inside it.
+	sort.SliceStable(existing, func(i, j int) bool {
+		return existing[i].Depth > existing[j].Depth
+	})
+	for _, g := range existing {
+		requests = append(requests, &sheets.Request{
+			DeleteDimensionGroup: &sheets.DeleteDimensionGroupRequest{Range: g.Range},
+		})
+	}
+	for _, g := range outline.groups {
+		requests = append(requests, &sheets.Request{
+			AddDimensionGroup: &sheets.AddDimensionGroupRequest{Range: &sheets.DimensionRange{
+				SheetId:    sheet.Properties.SheetId,
+				Dimension:  "ROWS",
+				StartIndex: int64(g.start),
+				EndIndex:   int64(g.end),
+			}},
+		})
+	}
+
+	_, err = srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}).Do()
+	return err
+}
+
+// Reports whether the existing groups are exactly the wanted ones.
+func sameGroups(existing []*sheets.DimensionGroup, groups []rowGroup) bool {
+	if len(existing) != len(groups) {
+		return false
+	}
+
+	want := map[rowGroup]int{}
+	for _, g := range groups {
+		want[g]++
+	}
+	for _, g := range existing {
+		key := rowGroup{start: int(g.Range.StartIndex), end: int(g.Range.EndIndex)}
+		if want[key] < 1 {
+			return false
+		}
+		want[key]--
+	}
+	return true
+}
@@ -0,0 +1,246 @@
+// Package a1 parses and formats spreadsheet ranges in A1 notation, e.g.
+// "'Sprint 25'!A4:B14".
+package a1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unbounded marks an open end of a range, e.g. the end row of "A4:A".
+const Unbounded = -1
+
+// Range is a rectangular block of cells. Rows and columns are zero based and
+// the end indexes are inclusive.
+type Range struct {
+	Sheet    string
+	StartRow int
+	StartCol int
+	EndRow   int
+	EndCol   int
+}
+
+// Cell returns the range of a single cell.
+func Cell(sheet string, row, col int) Range {
+	return Range{Sheet: sheet, StartRow: row, StartCol: col, EndRow: row, EndCol: col}
+}
+
+// Parse parses ranges like "Sheet1!A1", "Sheet1!A1:B2", "'My Sheet'!A:A",
+// "Sheet1!A4:A" and "Sheet1" (the whole sheet). The sheet is optional.
+func Parse(s string) (Range, error) {
+	r := Range{StartRow: 0, StartCol: 0, EndRow: Unbounded, EndCol: Unbounded}
+
+	sheet, cells, err := splitSheet(s)
+	if err != nil {
+		return r, err
+	}
+	r.Sheet = sheet
+	if len(cells) < 1 {
+		return r, nil
+	}
+
+	parts := strings.Split(cells, ":")
+	if len(parts) > 2 {
+		return r, fmt.Errorf("invalid range %q", s)
+	}
+
+	row, col, err := parseCell(parts[0])
+	if err != nil {
+		return r, fmt.Errorf("invalid range %q: %v", s, err)
+	}
+	if row == Unbounded {
+		row = 0
+	}
+	if col == Unbounded {
+		col = 0
+	}
+	r.StartRow, r.StartCol = row, col
+
+	if len(parts) == 1 {
+		r.EndRow, r.EndCol = r.StartRow, r.StartCol
+		return r, nil
+	}
+
+	r.EndRow, r.EndCol, err = parseCell(parts[1])
+	if err != nil {
+		return r, fmt.Errorf("invalid range %q: %v", s, err)
+	}
+	if r.EndRow != Unbounded && r.EndRow < r.StartRow || r.EndCol != Unbounded && r.EndCol < r.StartCol {
+		return r, fmt.Errorf("invalid range %q: end before start", s)
+	}
+	return r, nil
+}
+
+// splitSheet splits "Sheet!A1:B2" into the unquoted sheet name and the cells.
+// A string without "!" is a sheet name if it doesn't look like cells.
+func splitSheet(s string) (string, string, error) {
+	if strings.HasPrefix(s, "'") {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			rest := s[i+1:]
+			if len(rest) == 0 {
+				return b.String(), "", nil
+			}
+			if rest[0] != '!' {
+				return "", "", fmt.Errorf("invalid range %q", s)
+			}
+			return b.String(), rest[1:], nil
+		}
+		return "", "", fmt.Errorf("invalid range %q: unterminated quote", s)
+	}
+
+	if idx := strings.LastIndex(s, "!"); idx >= 0 {
+		return s[:idx], s[idx+1:], nil
+	}
+	if looksLikeCells(s) {
+		return "", s, nil
+	}
+	return s, "", nil
+}
+
+func looksLikeCells(s string) bool {
+	for _, part := range strings.Split(s, ":") {
+		if _, _, err := parseCell(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCell parses "B4", "B" or "4". Missing parts are Unbounded.
+func parseCell(s string) (int, int, error) {
+	i := 0
+	for i < len(s) && isLetter(s[i]) {
+		i++
+	}
+	letters, digits := s[:i], s[i:]
+	if len(letters) < 1 && len(digits) < 1 {
+		return 0, 0, fmt.Errorf("empty cell reference")
+	}
+	// Sheets has at most 18278 columns, "ZZZ".
+	if len(letters) > 3 {
+		return 0, 0, fmt.Errorf("invalid column %q", letters)
+	}
+
+	col := Unbounded
+	if len(letters) > 0 {
+		c, err := ColumnIndex(letters)
+		if err != nil {
+			return 0, 0, err
+		}
+		col = c
+	}
+
+	row := Unbounded
+	if len(digits) > 0 {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid row %q", digits)
+		}
+		row = n - 1
+	}
+	return row, col, nil
+}
+
+func isLetter(b byte) bool {
+	return b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z'
+}
+
+// ColumnIndex converts a column name like "A" or "AB" to its zero based
+// index.
+func ColumnIndex(name string) (int, error) {
+	if len(name) < 1 {
+		return 0, fmt.Errorf("empty column")
+	}
+	idx := 0
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < 'A' || c > 'Z' {
+			return 0, fmt.Errorf("invalid column %q", name)
+		}
+		idx = idx*26 + int(c-'A'+1)
+	}
+	return idx - 1, nil
+}
+
+// ColumnName converts a zero based column index to its name, e.g. 27 to "AB".
+func ColumnName(idx int) string {
+	name := ""
+	for idx >= 0 {
+		name = string(rune('A'+idx%26)) + name
+		idx = idx/26 - 1
+	}
+	return name
+}
+
+// QuoteSheet quotes a sheet name for use in a range if needed.
+func QuoteSheet(sheet string) string {
+	for _, c := range sheet {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return "'" + strings.Replace(sheet, "'", "''", -1) + "'"
+		}
+	}
+	return sheet
+}
+
+// Rows returns the number of rows, or Unbounded.
+func (r Range) Rows() int {
+	if r.EndRow == Unbounded {
+		return Unbounded
+	}
+	return r.EndRow - r.StartRow + 1
+}
+
+// Cols returns the number of columns, or Unbounded.
+func (r Range) Cols() int {
+	if r.EndCol == Unbounded {
+		return Unbounded
+	}
+	return r.EndCol - r.StartCol + 1
+}
+
+// Offset returns the cell at the given offset from the top left corner.
+func (r Range) Offset(rows, cols int) Range {
+	return Cell(r.Sheet, r.StartRow+rows, r.StartCol+cols)
+}
+
+// String formats the range in A1 notation, e.g. "'Sprint 25'!A4:B14".
+func (r Range) String() string {
+	if r.EndRow == Unbounded && r.EndCol == Unbounded && len(r.Sheet) > 0 {
+		return QuoteSheet(r.Sheet)
+	}
+
+	start := cellName(r.StartRow, r.StartCol)
+	cells := start
+	if r.EndRow != r.StartRow || r.EndCol != r.StartCol {
+		cells += ":" + cellName(r.EndRow, r.EndCol)
+	}
+	if len(r.Sheet) < 1 {
+		return cells
+	}
+	return QuoteSheet(r.Sheet) + "!" + cells
+}
+
+func cellName(row, col int) string {
+	name := ""
+	if col != Unbounded {
+		name = ColumnName(col)
+	}
+	if row != Unbounded {
+		name += strconv.Itoa(row + 1)
+	}
+	return name
+}
@@ -0,0 +1,874 @@
+// Package fakesheets is an in-memory stand-in for the parts of the Google
+// Sheets v4 API that gsheet-updater uses. Serve it with net/http/httptest and
+// point the sheets service at it with option.WithEndpoint.
+package fakesheets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gogolok/gsheet-updater/internal/a1"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Server serves the Sheets v4 API from in-memory grids.
+type Server struct {
+	mu           sync.Mutex
+	spreadsheets map[string]*spreadsheet
+	calls        []string
+}
+
+type spreadsheet struct {
+	sheets         []*sheet
+	namedRanges    []*namedRange
+	metadata       []*sheets.DeveloperMetadata
+	nextSheetID    int64
+	nextRangeID    int
+	nextMetadataID int64
+}
+
+type sheet struct {
+	id        int64
+	title     string
+	cells     map[cell]interface{}
+	rowGroups []*sheets.DimensionGroup
+}
+
+type cell struct {
+	row int
+	col int
+}
+
+type namedRange struct {
+	id   string
+	name string
+	rng  a1.Range
+}
+
+// apiError is turned into the JSON error body the API answers with.
+type apiError struct {
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) *apiError {
+	return &apiError{code: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// New returns an empty server.
+func New() *Server {
+	return &Server{
+		spreadsheets: make(map[string]*spreadsheet),
+	}
+}
+
+// AddSheet adds a sheet (tab) to the spreadsheet, creating the spreadsheet
+// if needed.
+func (s *Server) AddSheet(spreadsheetID, title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spreadsheet(spreadsheetID).addSheet(title)
+}
+
+// SetValues stores rows of values starting at the top left cell of rng as
+// given, without parsing them as user input.
+func (s *Server) SetValues(spreadsheetID, rng string, values [][]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.spreadsheets[spreadsheetID]
+	if !ok {
+		return fmt.Errorf("unknown spreadsheet %q", spreadsheetID)
+	}
+	r, sh, err := ss.resolve(rng)
+	if err != nil {
+		return err
+	}
+	return sh.write(r, values, "RAW")
+}
+
+// Values returns the unformatted values in rng, trimmed like the API does.
+func (s *Server) Values(spreadsheetID, rng string) ([][]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.spreadsheets[spreadsheetID]
+	if !ok {
+		return nil, fmt.Errorf("unknown spreadsheet %q", spreadsheetID)
+	}
+	r, sh, err := ss.resolve(rng)
+	if err != nil {
+		return nil, err
+	}
+	_, values := sh.read(r, "UNFORMATTED_VALUE")
+	return values, nil
+}
+
+// AddNamedRange names rng, e.g. "LaneHours" for "Sprint 25!B4:B14".
+func (s *Server) AddNamedRange(spreadsheetID, name, rng string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.spreadsheets[spreadsheetID]
+	if !ok {
+		return fmt.Errorf("unknown spreadsheet %q", spreadsheetID)
+	}
+	r, _, err := ss.resolve(rng)
+	if err != nil {
+		return err
+	}
+	ss.addNamedRange(name, r)
+	return nil
+}
+
+// AddDeveloperMetadata attaches key and value to whole rows or columns, e.g.
+// "Sprint 25!19:19" or "Sprint 25!G:G".
+func (s *Server) AddDeveloperMetadata(spreadsheetID, key, value, rng string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.spreadsheets[spreadsheetID]
+	if !ok {
+		return fmt.Errorf("unknown spreadsheet %q", spreadsheetID)
+	}
+	r, sh, err := ss.resolve(rng)
+	if err != nil {
+		return err
+	}
+
+	location := &sheets.DeveloperMetadataLocation{}
+	switch {
+	case r.StartCol == 0 && r.EndCol == a1.Unbounded && r.EndRow != a1.Unbounded:
+		location.DimensionRange = &sheets.DimensionRange{
+			SheetId:    sh.id,
+			Dimension:  "ROWS",
+			StartIndex: int64(r.StartRow),
+			EndIndex:   int64(r.EndRow + 1),
+		}
+	case r.StartRow == 0 && r.EndRow == a1.Unbounded && r.EndCol != a1.Unbounded:
+		location.DimensionRange = &sheets.DimensionRange{
+			SheetId:    sh.id,
+			Dimension:  "COLUMNS",
+			StartIndex: int64(r.StartCol),
+			EndIndex:   int64(r.EndCol + 1),
+		}
+	default:
+		return fmt.Errorf("%s is neither whole rows nor whole columns", rng)
+	}
+	_, err = ss.addMetadata(&sheets.DeveloperMetadata{
+		MetadataKey:   key,
+		MetadataValue: value,
+		Location:      location,
+		Visibility:    "DOCUMENT",
+	})
+	return err
+}
+
+// RowGroups returns the row groups of a sheet.
+func (s *Server) RowGroups(spreadsheetID, title string) []*sheets.DimensionGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.spreadsheets[spreadsheetID]
+	if !ok {
+		return nil
+	}
+	sh := ss.sheetByTitle(title)
+	if sh == nil {
+		return nil
+	}
+	return append([]*sheets.DimensionGroup(nil), sh.rowGroups...)
+}
+
+// Calls returns the API methods called so far, e.g. "values.update".
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.calls...)
+}
+
+func (s *Server) spreadsheet(id string) *spreadsheet {
+	ss, ok := s.spreadsheets[id]
+	if !ok {
+		ss = &spreadsheet{}
+		s.spreadsheets[id] = ss
+	}
+	return ss
+}
+
+func (ss *spreadsheet) addSheet(title string) *sheet {
+	sh := &sheet{
+		id:    ss.nextSheetID,
+		title: title,
+		cells: make(map[cell]interface{}),
+	}
+	ss.nextSheetID++
+	ss.sheets = append(ss.sheets, sh)
+	return sh
+}
+
+func (ss *spreadsheet) addNamedRange(name string, r a1.Range) *namedRange {
+	ss.nextRangeID++
+	nr := &namedRange{id: strconv.Itoa(ss.nextRangeID), name: name, rng: r}
+	ss.namedRanges = append(ss.namedRanges, nr)
+	return nr
+}
+
+// addMetadata stores a copy of md with a new id and its location type set.
+func (ss *spreadsheet) addMetadata(md *sheets.DeveloperMetadata) (*sheets.DeveloperMetadata, error) {
+	if len(md.MetadataKey) < 1 || md.Location == nil {
+		return nil, badRequest("Invalid developer metadata")
+	}
+	stored := *md
+	location := *md.Location
+	switch {
+	case location.Spreadsheet:
+		location.LocationType = "SPREADSHEET"
+	case location.DimensionRange != nil:
+		dr := location.DimensionRange
+		if ss.sheetByID(dr.SheetId) == nil || dr.EndIndex <= dr.StartIndex {
+			return nil, badRequest("Invalid developer metadata location")
+		}
+		location.LocationType = strings.TrimSuffix(dr.Dimension, "S")
+	default:
+		if ss.sheetByID(location.SheetId) == nil {
+			return nil, badRequest("No grid with id: %d", location.SheetId)
+		}
+		location.LocationType = "SHEET"
+	}
+	stored.Location = &location
+
+	ss.nextMetadataID++
+	stored.MetadataId = ss.nextMetadataID
+	ss.metadata = append(ss.metadata, &stored)
+	return &stored, nil
+}
+
+func (ss *spreadsheet) sheetByTitle(title string) *sheet {
+	for _, sh := range ss.sheets {
+		if sh.title == title {
+			return sh
+		}
+	}
+	return nil
+}
+
+func (ss *spreadsheet) sheetByID(id int64) *sheet {
+	for _, sh := range ss.sheets {
+		if sh.id == id {
+			return sh
+		}
+	}
+	return nil
+}
+
+// resolve turns a named range or A1 range into a range on an existing sheet.
+func (ss *spreadsheet) resolve(rng string) (a1.Range, *sheet, error) {
+	for _, nr := range ss.namedRanges {
+		if nr.name == rng {
+			return nr.rng, ss.sheetByTitle(nr.rng.Sheet), nil
+		}
+	}
+
+	r, err := a1.Parse(rng)
+	if err != nil {
+		return r, nil, badRequest("Unable to parse range: %s", rng)
+	}
+	if len(r.Sheet) < 1 {
+		if len(ss.sheets) < 1 {
+			return r, nil, badRequest("Unable to parse range: %s", rng)
+		}
+		r.Sheet = ss.sheets[0].title
+	}
+	sh := ss.sheetByTitle(r.Sheet)
+	if sh == nil {
+		return r, nil, badRequest("Unable to parse range: %s", rng)
+	}
+	return r, sh, nil
+}
+
+func (ss *spreadsheet) gridRange(r a1.Range) *sheets.GridRange {
+	gr := &sheets.GridRange{
+		SheetId:          ss.sheetByTitle(r.Sheet).id,
+		StartRowIndex:    int64(r.StartRow),
+		StartColumnIndex: int64(r.StartCol),
+	}
+	if r.EndRow != a1.Unbounded {
+		gr.EndRowIndex = int64(r.EndRow + 1)
+	}
+	if r.EndCol != a1.Unbounded {
+		gr.EndColumnIndex = int64(r.EndCol + 1)
+	}
+	return gr
+}
+
+func (ss *spreadsheet) a1Range(gr *sheets.GridRange) (a1.Range, error) {
+	sh := ss.sheetByID(gr.SheetId)
+	if sh == nil {
+		return a1.Range{}, badRequest("No grid with id: %d", gr.SheetId)
+	}
+	r := a1.Range{
+		Sheet:    sh.title,
+		StartRow: int(gr.StartRowIndex),
+		StartCol: int(gr.StartColumnIndex),
+		EndRow:   a1.Unbounded,
+		EndCol:   a1.Unbounded,
+	}
+	if gr.EndRowIndex > 0 {
+		r.EndRow = int(gr.EndRowIndex) - 1
+	}
+	if gr.EndColumnIndex > 0 {
+		r.EndCol = int(gr.EndColumnIndex) - 1
+	}
+	return r, nil
+}
+
+// extent returns the last row and column holding data, or -1.
+func (sh *sheet) extent() (int, int) {
+	maxRow, maxCol := -1, -1
+	for c := range sh.cells {
+		if c.row > maxRow {
+			maxRow = c.row
+		}
+		if c.col > maxCol {
+			maxCol = c.col
+		}
+	}
+	return maxRow, maxCol
+}
+
+// read returns the bounded range and its values. Trailing empty cells and
+// rows are dropped like the API does.
+func (sh *sheet) read(r a1.Range, render string) (a1.Range, [][]interface{}) {
+	maxRow, maxCol := sh.extent()
+	if r.EndRow == a1.Unbounded {
+		r.EndRow = maxRow
+		if r.EndRow < r.StartRow {
+			r.EndRow = r.StartRow
+		}
+	}
+	if r.EndCol == a1.Unbounded {
+		r.EndCol = maxCol
+		if r.EndCol < r.StartCol {
+			r.EndCol = r.StartCol
+		}
+	}
+
+	values := [][]interface{}{}
+	for row := r.StartRow; row <= r.EndRow; row++ {
+		line := []interface{}{}
+		for col := r.StartCol; col <= r.EndCol; col++ {
+			v, ok := sh.cells[cell{row, col}]
+			if !ok {
+				line = append(line, "")
+				continue
+			}
+			line = append(line, formatValue(v, render))
+		}
+		for len(line) > 0 && line[len(line)-1] == "" {
+			line = line[:len(line)-1]
+		}
+		values = append(values, line)
+	}
+	for len(values) > 0 && len(values[len(values)-1]) == 0 {
+		values = values[:len(values)-1]
+	}
+	return r, values
+}
+
+// write stores values starting at the top left cell of r. A nil value
+// leaves the cell unchanged and an empty string clears it.
+func (sh *sheet) write(r a1.Range, values [][]interface{}, inputOption string) error {
+	if err := fits(r, values); err != nil {
+		return err
+	}
+
+	for i, line := range values {
+		for j, v := range line {
+			c := cell{r.StartRow + i, r.StartCol + j}
+			switch {
+			case v == nil:
+			case v == "":
+				delete(sh.cells, c)
+			default:
+				sh.cells[c] = parseInput(v, inputOption)
+			}
+		}
+	}
+	return nil
+}
+
+// fits checks that values don't exceed a bounded range.
+func fits(r a1.Range, values [][]interface{}) error {
+	if r.Rows() != a1.Unbounded && len(values) > r.Rows() {
+		return badRequest("Requested writing within range [%s], but tried writing to row [%d]", r, r.StartRow+len(values))
+	}
+	for _, line := range values {
+		if r.Cols() != a1.Unbounded && len(line) > r.Cols() {
+			return badRequest("Requested writing within range [%s], but tried writing to column [%s]", r, a1.ColumnName(r.StartCol+len(line)-1))
+		}
+	}
+	return nil
+}
+
+// parseInput converts a value like the API does for USER_ENTERED input.
+func parseInput(v interface{}, inputOption string) interface{} {
+	s, ok := v.(string)
+	if !ok || inputOption != "USER_ENTERED" {
+		return v
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	switch strings.ToUpper(s) {
+	case "TRUE":
+		return true
+	case "FALSE":
+		return false
+	}
+	return s
+}
+
+func formatValue(v interface{}, render string) interface{} {
+	if render == "UNFORMATTED_VALUE" {
+		return v
+	}
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	default:
+		return v
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp, err := s.route(req)
+	if err != nil {
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			apiErr = badRequest("%v", err)
+		}
+		writeJSON(w, apiErr.code, map[string]interface{}{
+			"error": map[string]interface{}{
+				"code":    apiErr.code,
+				"message": apiErr.message,
+			},
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// route dispatches "/v4/spreadsheets/{id}[...]" to the handlers.
+func (s *Server) route(req *http.Request) (interface{}, error) {
+	segments := strings.Split(strings.TrimPrefix(req.URL.EscapedPath(), "/"), "/")
+	for i, seg := range segments {
+		unescaped, err := url.PathUnescape(seg)
+		if err != nil {
+			return nil, badRequest("invalid path %s", req.URL.Path)
+		}
+		segments[i] = unescaped
+	}
+	if len(segments) < 3 || segments[0] != "v4" || segments[1] != "spreadsheets" {
+		return nil, &apiError{code: http.StatusNotFound, message: "Not found: " + req.URL.Path}
+	}
+
+	id, rest := segments[2], segments[3:]
+	method := ""
+	if idx := strings.Index(id, ":"); idx >= 0 {
+		id, method = id[:idx], id[idx+1:]
+	}
+
+	ss, ok := s.spreadsheets[id]
+	if !ok {
+		return nil, &apiError{code: http.StatusNotFound, message: "Requested entity was not found."}
+	}
+
+	switch {
+	case len(rest) == 0 && method == "" && req.Method == http.MethodGet:
+		s.calls = append(s.calls, "spreadsheets.get")
+		return ss.get(id), nil
+	case len(rest) == 0 && method == "batchUpdate" && req.Method == http.MethodPost:
+		s.calls = append(s.calls, "spreadsheets.batchUpdate")
+		return ss.batchUpdate(id, req)
+	case len(rest) == 1 && rest[0] == "developerMetadata:search" && req.Method == http.MethodPost:
+		s.calls = append(s.calls, "developerMetadata.search")
+		return ss.searchMetadata(req)
+	case len(rest) == 1 && rest[0] == "values:batchGet" && req.Method == http.MethodGet:
+		s.calls = append(s.calls, "values.batchGet")
+		return ss.valuesBatchGet(id, req)
+	case len(rest) == 1 && rest[0] == "values:batchUpdate" && req.Method == http.MethodPost:
+		s.calls = append(s.calls, "values.batchUpdate")
+		return ss.valuesBatchUpdate(id, req)
+	case len(rest) == 2 && rest[0] == "values" && strings.HasSuffix(rest[1], ":append") && req.Method == http.MethodPost:
+		s.calls = append(s.calls, "values.append")
+		return ss.valuesAppend(id, strings.TrimSuffix(rest[1], ":append"), req)
+	case len(rest) == 2 && rest[0] == "values" && req.Method == http.MethodGet:
+		s.calls = append(s.calls, "values.get")
+		return ss.valuesGet(rest[1], req.URL.Query().Get("valueRenderOption"))
+	case len(rest) == 2 && rest[0] == "values" && req.Method == http.MethodPut:
+		s.calls = append(s.calls, "values.update")
+		return ss.valuesUpdate(id, rest[1], req)
+	}
+	return nil, &apiError{code: http.StatusNotFound, message: "Not found: " + req.URL.Path}
+}
+
+func (ss *spreadsheet) get(id string) *sheets.Spreadsheet {
+	resp := &sheets.Spreadsheet{SpreadsheetId: id}
+	for i, sh := range ss.sheets {
+		resp.Sheets = append(resp.Sheets, &sheets.Sheet{
+			Properties: &sheets.SheetProperties{
+				SheetId: sh.id,
+				Title:   sh.title,
+				Index:   int64(i),
+			},
+			RowGroups: sh.rowGroups,
+		})
+	}
+	for _, nr := range ss.namedRanges {
+		resp.NamedRanges = append(resp.NamedRanges, &sheets.NamedRange{
+			NamedRangeId: nr.id,
+			Name:         nr.name,
+			Range:        ss.gridRange(nr.rng),
+		})
+	}
+	return resp
+}
+
+// searchMetadata supports data filters looking up metadata by key, value or
+// id.
+func (ss *spreadsheet) searchMetadata(req *http.Request) (*sheets.SearchDeveloperMetadataResponse, error) {
+	var body sheets.SearchDeveloperMetadataRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, badRequest("Invalid JSON payload: %v", err)
+	}
+
+	resp := &sheets.SearchDeveloperMetadataResponse{}
+	for _, filter := range body.DataFilters {
+		lookup := filter.DeveloperMetadataLookup
+		if lookup == nil {
+			return nil, badRequest("Only developerMetadataLookup data filters are supported")
+		}
+		for _, md := range ss.metadata {
+			if len(lookup.MetadataKey) > 0 && lookup.MetadataKey != md.MetadataKey ||
+				len(lookup.MetadataValue) > 0 && lookup.MetadataValue != md.MetadataValue ||
+				lookup.MetadataId != 0 && lookup.MetadataId != md.MetadataId {
+				continue
+			}
+			resp.MatchedDeveloperMetadata = append(resp.MatchedDeveloperMetadata, &sheets.MatchedDeveloperMetadata{
+				DeveloperMetadata: md,
+				DataFilters:       []*sheets.DataFilter{filter},
+			})
+		}
+	}
+	return resp, nil
+}
+
+func (ss *spreadsheet) valuesGet(rng, render string) (*sheets.ValueRange, error) {
+	r, sh, err := ss.resolve(rng)
+	if err != nil {
+		return nil, err
+	}
+	bounded, values := sh.read(r, render)
+	return &sheets.ValueRange{
+		Range:          bounded.String(),
+		MajorDimension: "ROWS",
+		Values:         values,
+	}, nil
+}
+
+func (ss *spreadsheet) valuesBatchGet(id string, req *http.Request) (*sheets.BatchGetValuesResponse, error) {
+	query := req.URL.Query()
+	resp := &sheets.BatchGetValuesResponse{SpreadsheetId: id}
+	for _, rng := range query["ranges"] {
+		vr, err := ss.valuesGet(rng, query.Get("valueRenderOption"))
+		if err != nil {
+			return nil, err
+		}
+		resp.ValueRanges = append(resp.ValueRanges, vr)
+	}
+	return resp, nil
+}
+
+func (ss *spreadsheet) valuesUpdate(id, rng string, req *http.Request) (*sheets.UpdateValuesResponse, error) {
+	inputOption := req.URL.Query().Get("valueInputOption")
+	if inputOption != "RAW" && inputOption != "USER_ENTERED" {
+		return nil, badRequest("Invalid valueInputOption: %q", inputOption)
+	}
+
+	var vr sheets.ValueRange
+	if err := json.NewDecoder(req.Body).Decode(&vr); err != nil {
+		return nil, badRequest("Invalid JSON payload: %v", err)
+	}
+	return ss.update(id, rng, vr.Values, inputOption)
+}
+
+func (ss *spreadsheet) update(id, rng string, values [][]interface{}, inputOption string) (*sheets.UpdateValuesResponse, error) {
+	r, sh, err := ss.resolve(rng)
+	if err != nil {
+		return nil, err
+	}
+	if err := sh.write(r, values, inputOption); err != nil {
+		return nil, err
+	}
+
+	rows, cols, cells := 0, 0, 0
+	for _, line := range values {
+		if len(line) > 0 {
+			rows++
+		}
+		if len(line) > cols {
+			cols = len(line)
+		}
+		cells += len(line)
+	}
+	updated := r
+	if rows > 0 && cols > 0 {
+		updated.EndRow, updated.EndCol = r.StartRow+len(values)-1, r.StartCol+cols-1
+	} else {
+		updated.EndRow, updated.EndCol = r.StartRow, r.StartCol
+	}
+
+	return &sheets.UpdateValuesResponse{
+		SpreadsheetId:  id,
+		UpdatedRange:   updated.String(),
+		UpdatedRows:    int64(rows),
+		UpdatedColumns: int64(cols),
+		UpdatedCells:   int64(cells),
+	}, nil
+}
+
+func (ss *spreadsheet) valuesBatchUpdate(id string, req *http.Request) (*sheets.BatchUpdateValuesResponse, error) {
+	var body sheets.BatchUpdateValuesRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, badRequest("Invalid JSON payload: %v", err)
+	}
+	if body.ValueInputOption != "RAW" && body.ValueInputOption != "USER_ENTERED" {
+		return nil, badRequest("Invalid valueInputOption: %q", body.ValueInputOption)
+	}
+
+	// Like the API, the request is applied as a whole: every range is
+	// checked before the first one is written.
+	for _, vr := range body.Data {
+		r, _, err := ss.resolve(vr.Range)
+		if err != nil {
+			return nil, err
+		}
+		if err := fits(r, vr.Values); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &sheets.BatchUpdateValuesResponse{SpreadsheetId: id}
+	for _, vr := range body.Data {
+		updated, err := ss.update(id, vr.Range, vr.Values, body.ValueInputOption)
+		if err != nil {
+			return nil, err
+		}
+		resp.Responses = append(resp.Responses, updated)
+		resp.TotalUpdatedCells += updated.UpdatedCells
+		resp.TotalUpdatedRows += updated.UpdatedRows
+		resp.TotalUpdatedColumns += updated.UpdatedColumns
+		resp.TotalUpdatedSheets = 1
+	}
+	return resp, nil
+}
+
+// valuesAppend writes below the last row holding data in the columns of the
+// range, like the API does for a table starting at the range.
+func (ss *spreadsheet) valuesAppend(id, rng string, req *http.Request) (*sheets.AppendValuesResponse, error) {
+	inputOption := req.URL.Query().Get("valueInputOption")
+	if inputOption != "RAW" && inputOption != "USER_ENTERED" {
+		return nil, badRequest("Invalid valueInputOption: %q", inputOption)
+	}
+
+	var vr sheets.ValueRange
+	if err := json.NewDecoder(req.Body).Decode(&vr); err != nil {
+		return nil, badRequest("Invalid JSON payload: %v", err)
+	}
+
+	r, sh, err := ss.resolve(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	last := r.StartRow - 1
+	for c := range sh.cells {
+		inCols := c.col >= r.StartCol && (r.EndCol == a1.Unbounded || c.col <= r.EndCol)
+		if inCols && c.row >= r.StartRow && c.row > last {
+			last = c.row
+		}
+	}
+
+	target := a1.Range{Sheet: r.Sheet, StartRow: last + 1, StartCol: r.StartCol, EndRow: a1.Unbounded, EndCol: a1.Unbounded}
+	updates, err := ss.update(id, target.String(), vr.Values, inputOption)
+	if err != nil {
+		return nil, err
+	}
+
+	table := r
+	table.EndRow = last
+	return &sheets.AppendValuesResponse{
+		SpreadsheetId: id,
+		TableRange:    table.String(),
+		Updates:       updates,
+	}, nil
+}
+
+func (ss *spreadsheet) batchUpdate(id string, req *http.Request) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	var body sheets.BatchUpdateSpreadsheetRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, badRequest("Invalid JSON payload: %v", err)
+	}
+
+	// Like the API, a failing request fails the whole batch, so the requests
+	// are applied to a copy that replaces the spreadsheet once all succeed.
+	next := ss.clone()
+	resp := &sheets.BatchUpdateSpreadsheetResponse{SpreadsheetId: id}
+	for _, r := range body.Requests {
+		reply, err := next.apply(r)
+		if err != nil {
+			return nil, err
+		}
+		resp.Replies = append(resp.Replies, reply)
+	}
+	*ss = *next
+	return resp, nil
+}
+
+// clone returns a copy of the spreadsheet that requests can change without
+// touching ss.
+func (ss *spreadsheet) clone() *spreadsheet {
+	c := *ss
+	c.sheets = make([]*sheet, len(ss.sheets))
+	for i, sh := range ss.sheets {
+		cs := *sh
+		cs.cells = make(map[cell]interface{}, len(sh.cells))
+		for k, v := range sh.cells {
+			cs.cells[k] = v
+		}
+		cs.rowGroups = make([]*sheets.DimensionGroup, len(sh.rowGroups))
+		for j, g := range sh.rowGroups {
+			cg := *g
+			cs.rowGroups[j] = &cg
+		}
+		c.sheets[i] = &cs
+	}
+	c.namedRanges = append([]*namedRange(nil), ss.namedRanges...)
+	c.metadata = append([]*sheets.DeveloperMetadata(nil), ss.metadata...)
+	return &c
+}
+
+func (ss *spreadsheet) apply(r *sheets.Request) (*sheets.Response, error) {
+	switch {
+	case r.AddSheet != nil:
+		title := ""
+		if r.AddSheet.Properties != nil {
+			title = r.AddSheet.Properties.Title
+		}
+		if len(title) < 1 || ss.sheetByTitle(title) != nil {
+			return nil, badRequest("Invalid sheet title %q", title)
+		}
+		sh := ss.addSheet(title)
+		return &sheets.Response{AddSheet: &sheets.AddSheetResponse{
+			Properties: &sheets.SheetProperties{SheetId: sh.id, Title: sh.title, Index: int64(len(ss.sheets) - 1)},
+		}}, nil
+
+	case r.AddNamedRange != nil && r.AddNamedRange.NamedRange != nil && r.AddNamedRange.NamedRange.Range != nil:
+		rng, err := ss.a1Range(r.AddNamedRange.NamedRange.Range)
+		if err != nil {
+			return nil, err
+		}
+		nr := ss.addNamedRange(r.AddNamedRange.NamedRange.Name, rng)
+		return &sheets.Response{AddNamedRange: &sheets.AddNamedRangeResponse{
+			NamedRange: &sheets.NamedRange{NamedRangeId: nr.id, Name: nr.name, Range: ss.gridRange(nr.rng)},
+		}}, nil
+
+	case r.DeleteNamedRange != nil:
+		for i, nr := range ss.namedRanges {
+			if nr.id == r.DeleteNamedRange.NamedRangeId {
+				ss.namedRanges = append(ss.namedRanges[:i], ss.namedRanges[i+1:]...)
+				return &sheets.Response{}, nil
+			}
+		}
+		return nil, badRequest("No named range with id %q", r.DeleteNamedRange.NamedRangeId)
+
+	case r.CreateDeveloperMetadata != nil && r.CreateDeveloperMetadata.DeveloperMetadata != nil:
+		md, err := ss.addMetadata(r.CreateDeveloperMetadata.DeveloperMetadata)
+		if err != nil {
+			return nil, err
+		}
+		return &sheets.Response{CreateDeveloperMetadata: &sheets.CreateDeveloperMetadataResponse{DeveloperMetadata: md}}, nil
+
+	case r.AddDimensionGroup != nil && r.AddDimensionGroup.Range != nil:
+		dr := r.AddDimensionGroup.Range
+		sh := ss.sheetByID(dr.SheetId)
+		if sh == nil || dr.Dimension != "ROWS" || dr.EndIndex <= dr.StartIndex {
+			return nil, badRequest("Invalid dimension group range")
+		}
+		sh.rowGroups = append(sh.rowGroups, &sheets.DimensionGroup{Range: dr, Depth: groupDepth(sh.rowGroups, dr) + 1})
+		sort.Slice(sh.rowGroups, func(i, j int) bool {
+			return sh.rowGroups[i].Range.StartIndex < sh.rowGroups[j].Range.StartIndex
+		})
+		return &sheets.Response{AddDimensionGroup: &sheets.AddDimensionGroupResponse{DimensionGroups: sh.rowGroups}}, nil
+
+	case r.DeleteDimensionGroup != nil && r.DeleteDimensionGroup.Range != nil:
+		dr := r.DeleteDimensionGroup.Range
+		sh := ss.sheetByID(dr.SheetId)
+		if sh == nil || dr.Dimension != "ROWS" {
+			return nil, badRequest("Invalid dimension group range")
+		}
+		deepest := -1
+		for i, g := range sh.rowGroups {
+			if g.Range.StartIndex == dr.StartIndex && g.Range.EndIndex == dr.EndIndex && (deepest < 0 || g.Depth > sh.rowGroups[deepest].Depth) {
+				deepest = i
+			}
+		}
+		if deepest < 0 {
+			return nil, badRequest("No dimension group over the range")
+		}
+		sh.rowGroups = append(sh.rowGroups[:deepest], sh.rowGroups[deepest+1:]...)
+		for i, g := range sh.rowGroups {
+			others := append(append([]*sheets.DimensionGroup(nil), sh.rowGroups[:i]...), sh.rowGroups[i+1:]...)
+			g.Depth = groupDepth(others, g.Range) + 1
+		}
+		return &sheets.Response{DeleteDimensionGroup: &sheets.DeleteDimensionGroupResponse{DimensionGroups: sh.rowGroups}}, nil
+	}
+
+	b, _ := json.Marshal(r)
+	return nil, badRequest("Unsupported request: %s", b)
+}
+
+// groupDepth returns how many existing groups contain dr.
+func groupDepth(groups []*sheets.DimensionGroup, dr *sheets.DimensionRange) int64 {
+	depth := int64(0)
+	for _, g := range groups {
+		if g.Range.StartIndex <= dr.StartIndex && g.Range.EndIndex >= dr.EndIndex {
+			depth++
+		}
+	}
+	return depth
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/sheets/v4"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	onFailureStop     = "stop"
+	onFailureContinue = "continue"
+)
+
+// jobConfig is a manifest of report steps run in order with one client:
+//
+//	defaults:
+//	  spreadsheet: 1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms
+//	  tab: Sprint 25
+//	steps:
+//	  - report: lane
+//	    file: lanes.csv
+//	    onFailure: continue
+//	  - report: hours
+//	    file: hoursbytag.csv
+//	  - report: last-run-timestamp
+type jobConfig struct {
+	Defaults reportConfig `yaml:"defaults"`
+	Steps    []jobStep    `yaml:"steps"`
+}
+
+type jobStep struct {
+	Report       string `yaml:"report"`
+	OnFailure    string `yaml:"onFailure"`
+	reportConfig `yaml:",inline"`
+}
+
+type stepResult struct {
+	settings reportSettings
+	err      error
+	skipped  bool
+	duration time.Duration
+}
+
+func newRunCmd() *cobra.Command {
+	var jobFile string
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the report steps of a job manifest",
+		Long: `Run an ordered list of report steps from a job manifest with one shared client.
+A failing step stops the job unless the step sets onFailure: continue.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runJob(jobFile, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&jobFile, "job", "", "YAML job manifest")
+	cmd.MarkFlagRequired("job")
+
+	return cmd
+}
+
+func loadJob(path string) (*jobConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read job file: %v", err)
+	}
+
+	job := &jobConfig{}
+	if err := yaml.UnmarshalStrict(b, job); err != nil {
+		return nil, fmt.Errorf("Unable to parse job file %s: %v", path, err)
+	}
+	if len(job.Steps) < 1 {
+		return nil, fmt.Errorf("Job file %s has no steps", path)
+	}
+	return job, nil
+}
+
+// Resolves the settings of every step, reporting all problems at once.
+func resolveJob(c *fileConfig, job *jobConfig) ([]reportSettings, error) {
+	problems := []string{}
+	steps := make([]reportSettings, 0, len(job.Steps))
+
+	for idx, step := range job.Steps {
+		prefix := fmt.Sprintf("steps[%d] (%s)", idx, step.Report)
+
+		known := false
+		for _, name := range reportNames {
+			known = known || step.Report == name
+		}
+		if !known {
+			problems = append(problems, fmt.Sprintf("%s: unknown report, must be one of %s", prefix, strings.Join(reportNames, ", ")))
+			continue
+		}
+
+		switch step.OnFailure {
+		case "", onFailureStop, onFailureContinue:
+		default:
+			problems = append(problems, fmt.Sprintf("%s: onFailure must be %q or %q", prefix, onFailureStop, onFailureContinue))
+		}
+		if len(step.Profile) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: profile can only be set in the job defaults, all steps share one client", prefix))
+		}
+
+		// The job defaults extend the config file, the environment variables
+		// override both and the step overrides everything.
+		s := settingsFromConfig(c, step.Report, job.Defaults)
+		s.reportConfig = s.reportConfig.merge(step.reportConfig)
+		if rootCmd.PersistentFlags().Changed("profile") {
+			s.Profile = clientOpts.profile
+		}
+		for _, problem := range s.problems() {
+			problems = append(problems, fmt.Sprintf("%s: %s", prefix, problem))
+		}
+		for _, field := range unusedLayoutFields(step.Report, step.Layout) {
+			problems = append(problems, fmt.Sprintf("%s: layout.%s does not apply to this report", prefix, field))
+		}
+		// A profile of the reports section of the config file would
+		// otherwise be ignored in favour of the first step's.
+		if len(steps) > 0 && s.Profile != steps[0].Profile {
+			problems = append(problems, fmt.Sprintf("%s: profile %q of the config file differs from %q of the first step, all steps share one client", prefix, s.Profile, steps[0].Profile))
+		}
+		steps = append(steps, s)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid job:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return steps, nil
+}
+
+func runJob(path string, out io.Writer) error {
+	c, err := loadConfig(configPath, rootCmd.PersistentFlags().Changed("config"))
+	if err != nil {
+		return err
+	}
+
+	job, err := loadJob(path)
+	if err != nil {
+		return err
+	}
+
+	steps, err := resolveJob(c, job)
+	if err != nil {
+		return err
+	}
+
+	srv, err := steps[0].sheetsService()
+	if err != nil {
+		return err
+	}
+
+	results := runSteps(srv, job, steps)
+	if reportOpts.output == outputJSON {
+		// Keep stdout parseable, it carries the JSON of the reports.
+		out = os.Stderr
+	}
+	return printJobSummary(out, results)
+}
+
+func runSteps(srv *sheets.Service, job *jobConfig, steps []reportSettings) []stepResult {
+	results := make([]stepResult, 0, len(steps))
+	stopped := false
+
+	for idx, settings := range steps {
+		if stopped {
+			results = append(results, stepResult{settings: settings, skipped: true})
+			continue
+		}
+
+		log.Infof("Running step %d: %s", idx, settings.name)
+		start := time.Now()
+		err := runReport(srv, settings)
+		results = append(results, stepResult{settings: settings, err: err, duration: time.Since(start)})
+
+		if err != nil {
+			log.Errorf("Step %d (%s) failed: %v", idx, settings.name, err)
+			stopped = job.Steps[idx].OnFailure != onFailureContinue
+		}
+	}
+
+	return results
+}
+
+func printJobSummary(out io.Writer, results []stepResult) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tREPORT\tTAB\tSTATUS\tDURATION")
+
+	failed := 0
+	for idx, result := range results {
+		status := "ok"
+		duration := result.duration.Round(time.Millisecond).String()
+		switch {
+		case result.skipped:
+			status = "skipped"
+			duration = "-"
+		case result.err != nil:
+			status = "failed: " + result.err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", idx, result.settings.name, result.settings.Tab, status, duration)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d steps failed", failed, len(results))
+	}
+	return nil
+}
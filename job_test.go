@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveJobStepOverridesEnvironment(t *testing.T) {
+	setenv(t, "TAB_ID", "From Env")
+	setenv(t, "SPREADSHEET_ID", "env-sheet")
+
+	job := &jobConfig{Steps: []jobStep{
+		{Report: reportLastRunTimestamp, reportConfig: reportConfig{Tab: "Step Tab"}},
+		{Report: reportLastRunTimestamp},
+	}}
+	steps, err := resolveJob(&fileConfig{Defaults: reportConfig{Tab: "File Tab"}}, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps[0].Tab != "Step Tab" {
+		t.Errorf("tab = %q, want the step's over TAB_ID", steps[0].Tab)
+	}
+	if steps[1].Tab != "From Env" || steps[1].Spreadsheet != "env-sheet" {
+		t.Errorf("tab = %q, spreadsheet = %q, want TAB_ID and SPREADSHEET_ID over the config file", steps[1].Tab, steps[1].Spreadsheet)
+	}
+}
+
+func TestResolveJobEnvironmentOverridesJobDefaults(t *testing.T) {
+	setenv(t, "TAB_ID", "From Env")
+
+	job := &jobConfig{
+		Defaults: reportConfig{Spreadsheet: "job-sheet", Tab: "Job Tab"},
+		Steps:    []jobStep{{Report: reportLastRunTimestamp}},
+	}
+	steps, err := resolveJob(&fileConfig{Defaults: reportConfig{Spreadsheet: "file-sheet", Tab: "File Tab"}}, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps[0].Tab != "From Env" {
+		t.Errorf("tab = %q, want TAB_ID over the job defaults", steps[0].Tab)
+	}
+	if steps[0].Spreadsheet != "job-sheet" {
+		t.Errorf("spreadsheet = %q, want the job defaults over the config file", steps[0].Spreadsheet)
+	}
+}
+
+func TestResolveJobRejectsProfilesOfConfigFile(t *testing.T) {
+	c := &fileConfig{
+		Defaults: reportConfig{Spreadsheet: "abc", Tab: "Sprint"},
+		Reports:  map[string]reportConfig{reportLane: {Profile: "work"}},
+	}
+	job := &jobConfig{Steps: []jobStep{
+		{Report: reportLastRunTimestamp},
+		{Report: reportLane, reportConfig: reportConfig{File: "lanes.csv"}},
+	}}
+	_, err := resolveJob(c, job)
+	if err == nil || !strings.Contains(err.Error(), `profile "work"`) {
+		t.Fatalf("err = %v, want the profile of reports.lane rejected", err)
+	}
+}
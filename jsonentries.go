@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Input formats of local time trackers.
+const (
+	// inputTimew is the output of timew export.
+	inputTimew = "timew"
+	// inputWatson is Watson's frames file, or the output of watson log --json.
+	inputWatson = "watson"
+)
+
+const timewLayout = "20060102T150405Z"
+
+// timewUntagged tags the intervals of timew export without tags, timew has no
+// project to fall back to.
+const timewUntagged = "untagged"
+
+// timewInterval is an interval of timew export. Open intervals have no end.
+type timewInterval struct {
+	Start      string   `json:"start"`
+	End        string   `json:"end"`
+	Tags       []string `json:"tags"`
+	Annotation string   `json:"annotation"`
+}
+
+// watsonFrame is a frame of watson log --json. The frames file stores the
+// same as [start, stop, project, id, tags, updated_at] with Unix times.
+type watsonFrame struct {
+	Start   time.Time `json:"start"`
+	Stop    time.Time `json:"stop"`
+	Project string    `json:"project"`
+	Tags    []string  `json:"tags"`
+}
+
+// Returns the intervals of timew export. Open intervals end now, intervals
+// without tags are tagged with timewUntagged.
+func parseTimewExport(filename string, now time.Time) ([]timeEntry, error) {
+	b, err := readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	intervals := []timewInterval{}
+	if err := json.Unmarshal(b, &intervals); err != nil {
+		return nil, fmt.Errorf("%s is not a timew export: %v", filename, err)
+	}
+
+	ret := make([]timeEntry, 0, len(intervals))
+	problems := []string{}
+	for idx, interval := range intervals {
+		entry := timeEntry{Tags: interval.Tags, Description: interval.Annotation, End: now}
+		if len(entry.Tags) == 0 {
+			entry.Tags = []string{timewUntagged}
+		}
+
+		entry.Start, err = time.Parse(timewLayout, interval.Start)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("interval %d: invalid start %q", idx, interval.Start))
+			continue
+		}
+		if len(interval.End) > 0 {
+			entry.End, err = time.Parse(timewLayout, interval.End)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("interval %d: invalid end %q", idx, interval.End))
+				continue
+			}
+		}
+		if entry.End.Before(entry.Start) {
+			problems = append(problems, fmt.Sprintf("interval %d: end %s is before start %s", idx, entry.End.Format(time.RFC3339), interval.Start))
+			continue
+		}
+		entry.Start, entry.End = entry.Start.Local(), entry.End.Local()
+		entry.Hours = entry.End.Sub(entry.Start).Hours()
+
+		ret = append(ret, entry)
+	}
+
+	if len(problems) > 0 {
+		return ret, fmt.Errorf("%d invalid interval(s) in %s:\n  %s", len(problems), filename, strings.Join(problems, "\n  "))
+	}
+	return ret, nil
+}
+
+// Returns the frames of Watson's frames file or of watson log --json.
+// Frames without tags are tagged with their project.
+func parseWatsonFrames(filename string) ([]timeEntry, error) {
+	b, err := readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := []json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s is not a list of Watson frames: %v", filename, err)
+	}
+
+	ret := make([]timeEntry, 0, len(raw))
+	problems := []string{}
+	for idx, r := range raw {
+		frame, err := decodeWatsonFrame(r)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("frame %d: %v", idx, err))
+			continue
+		}
+		if frame.Stop.Before(frame.Start) {
+			problems = append(problems, fmt.Sprintf("frame %d: stop is before start", idx))
+			continue
+		}
+
+		entry := timeEntry{
+			Start: frame.Start.Local(),
+			End:   frame.Stop.Local(),
+			Hours: frame.Stop.Sub(frame.Start).Hours(),
+			Tags:  frame.Tags,
+		}
+		if len(entry.Tags) == 0 {
+			entry.Tags = []string{frame.Project}
+		}
+		ret = append(ret, entry)
+	}
+
+	if len(problems) > 0 {
+		return ret, fmt.Errorf("%d invalid frame(s) in %s:\n  %s", len(problems), filename, strings.Join(problems, "\n  "))
+	}
+	return ret, nil
+}
+
+// Decodes a frame of the frames file, an array, or of watson log --json, an
+// object.
+func decodeWatsonFrame(r json.RawMessage) (watsonFrame, error) {
+	frame := watsonFrame{}
+	if !strings.HasPrefix(strings.TrimSpace(string(r)), "[") {
+		return frame, json.Unmarshal(r, &frame)
+	}
+
+	fields := []json.RawMessage{}
+	if err := json.Unmarshal(r, &fields); err != nil {
+		return frame, err
+	}
+	if len(fields) < 3 {
+		return frame, fmt.Errorf("expected at least start, stop and project, got %d fields", len(fields))
+	}
+
+	var start, stop float64
+	if err := json.Unmarshal(fields[0], &start); err != nil {
+		return frame, fmt.Errorf("invalid start: %v", err)
+	}
+	if err := json.Unmarshal(fields[1], &stop); err != nil {
+		return frame, fmt.Errorf("invalid stop: %v", err)
+	}
+	if err := json.Unmarshal(fields[2], &frame.Project); err != nil {
+		return frame, fmt.Errorf("invalid project: %v", err)
+	}
+	if len(fields) > 4 {
+		if err := json.Unmarshal(fields[4], &frame.Tags); err != nil {
+			return frame, fmt.Errorf("invalid tags: %v", err)
+		}
+	}
+	frame.Start = time.Unix(int64(start), 0)
+	frame.Stop = time.Unix(int64(stop), 0)
+	return frame, nil
+}
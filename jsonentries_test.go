@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// watsonLog is testdata/watson.json as printed by watson log --json.
+const watsonLog = `[
+  {"id": "0b8e1c5a", "project": "website", "start": "2020-06-01T07:00:00+00:00", "stop": "2020-06-01T08:30:00+00:00", "tags": ["dev", "review"]},
+  {"id": "1c9f2d6b", "project": "website", "start": "2020-06-01T11:00:00+02:00", "stop": "2020-06-01T13:00:00+02:00", "tags": ["dev"]},
+  {"id": "2da03e7c", "project": "internal", "start": "2020-06-02T06:00:00+00:00", "stop": "2020-06-02T07:15:00+00:00", "tags": ["ops"]},
+  {"id": "3eb14f8d", "project": "internal", "start": "2020-06-02T12:00:00+00:00", "stop": "2020-06-02T12:45:00+00:00", "tags": []}
+]`
+
+var watsonHours = map[string]float64{"dev": 2.75, "review": 0.75, "ops": 1.25, "internal": 0.75}
+
+func TestParseTimewExport(t *testing.T) {
+	got, err := ParseInputFile("testdata/timew.json", inputOptions{format: inputTimew}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHours(t, got, map[string]float64{"dev": 2.75, "review": 0.75, "ops": 1.25, timewUntagged: 0.75})
+}
+
+func TestParseTimewExportOpenInterval(t *testing.T) {
+	path := writeInput(t, `[
+{"id":2,"start":"20200601T070000Z","end":"20200601T080000Z","tags":["dev"]},
+{"id":1,"start":"20200601T090000Z","tags":["ops"]}
+]`)
+	now := time.Date(2020, 6, 1, 10, 30, 0, 0, time.UTC)
+
+	entries, err := parseTimewExport(path, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	open := entries[1]
+	if !open.End.Equal(now) || math.Abs(open.Hours-1.5) > 1e-9 {
+		t.Errorf("open interval ends %v after %v hours, want %v after 1.5 hours", open.End, open.Hours, now)
+	}
+
+	if _, err := parseTimewExport(path, now.Add(-2*time.Hour)); err == nil {
+		t.Error("an open interval starting after now was accepted")
+	}
+}
+
+func TestParseWatsonFrames(t *testing.T) {
+	got, err := ParseInputFile("testdata/watson.json", inputOptions{format: inputWatson}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHours(t, got, watsonHours)
+}
+
+func TestParseWatsonLog(t *testing.T) {
+	got, err := ParseInputFile(writeInput(t, watsonLog), inputOptions{format: inputWatson}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHours(t, got, watsonHours)
+}
+
+func TestParseJSONEntriesFromStdin(t *testing.T) {
+	f, err := os.Open("testdata/watson.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	got, err := ParseInputFile("-", inputOptions{format: inputWatson}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHours(t, got, watsonHours)
+}
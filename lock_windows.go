@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// staleLockAge is the age after which a lock file is taken to be left
+	// behind by a crashed run and removed.
+	staleLockAge = time.Minute
+	// lockTimeout is how long to wait for a lock held by another run.
+	lockTimeout = 2 * time.Minute
+)
+
+// Takes an exclusive lock by creating path, waiting while another process
+// holds it. The returned function releases the lock.
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return func() {
+				f.Close()
+				os.Remove(path)
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for lock %s, remove it if no other run is active", path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -5,8 +5,8 @@ import (
 	"io"
 	"os"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"google.golang.org/api/sheets/v4"
 )
 
 var Version = undefinedVersion
@@ -22,12 +22,63 @@ var rootCmd = &cobra.Command{
 	Short: "gsheet-udpater is a CLI to update lanes in google docs.",
 	Long:  `gsheet-udpater is a CLI to update lanes in google docs.`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		return nil
+		clientOpts.readOnly = cmd.Annotations[readOnlyAnnotation] == "true" || reportOpts.dryRun
+		if err := reportOpts.validate(); err != nil {
+			return err
+		}
+		return clientOpts.validate()
 	},
 }
 
+// reportOptions holds the global flags controlling how reports are run.
+type reportOptions struct {
+	// dryRun prints the planned changes instead of writing them.
+	dryRun bool
+	// output selects text or json output.
+	output string
+}
+
+func newReportOptions() *reportOptions {
+	return &reportOptions{
+		output: outputText,
+	}
+}
+
+func (o *reportOptions) validate() error {
+	if o.output != outputText && o.output != outputJSON {
+		return fmt.Errorf("Unknown output format %q, must be %q or %q", o.output, outputText, outputJSON)
+	}
+	return nil
+}
+
+var reportOpts = newReportOptions()
+
+// readOnlyAnnotation marks commands that only read from the sheet, so they
+// request the read-only scope.
+const readOnlyAnnotation = "gsheet-updater/read-only"
+
+// clientOpts holds the global flags used to build the authenticated client.
+var clientOpts = newClientOptions()
+
 func init() {
+	rootCmd.PersistentFlags().StringVar(&clientOpts.authMethod, "auth", clientOpts.authMethod, "Interactive login for user credentials: browser or device (Google's device flow rejects the spreadsheets scope)")
+	rootCmd.PersistentFlags().StringVar(&clientOpts.credentialsFile, "credentials-file", clientOpts.credentialsFile, "Service account key or Google credentials JSON file (default $GOOGLE_APPLICATION_CREDENTIALS)")
+	rootCmd.PersistentFlags().StringVar(&clientOpts.impersonate, "impersonate", clientOpts.impersonate, "Workspace user the service account acts as via domain-wide delegation (default $IMPERSONATE_USER)")
+	rootCmd.PersistentFlags().StringSliceVar(&clientOpts.scopes, "scopes", clientOpts.scopes, "OAuth scopes to request, e.g. spreadsheets.readonly (default depends on the command)")
+
+	rootCmd.PersistentFlags().StringVar(&clientOpts.apiEndpoint, "api-endpoint", clientOpts.apiEndpoint, "Base URL of the Sheets API, e.g. a local fake server")
+	rootCmd.PersistentFlags().StringVar(&clientOpts.profile, "profile", clientOpts.profile, "Named auth profile in $XDG_CONFIG_HOME/gsheet-updater/")
+
+	rootCmd.PersistentFlags().BoolVar(&reportOpts.dryRun, "dry-run", reportOpts.dryRun, "Print a cell-level diff of the planned changes instead of writing them")
+	rootCmd.PersistentFlags().StringVarP(&reportOpts.output, "output", "o", reportOpts.output, "Output format: text or json")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", configPath, "YAML config file with the report settings")
+
 	rootCmd.AddCommand(newCmdVersion())
+	rootCmd.AddCommand(newConfigCmd())
+	rootCmd.AddCommand(newRulesCmd())
+	rootCmd.AddCommand(newRunCmd())
+	rootCmd.AddCommand(newAuthCmd())
+	rootCmd.AddCommand(newCredsCmd())
 	rootCmd.AddCommand(newLaneReport())
 	rootCmd.AddCommand(newHoursReport())
 	rootCmd.AddCommand(newLastRunTimestamp())
@@ -72,34 +123,63 @@ func runVersion(options *versionOptions, stdout io.Writer) {
 
 func newLaneReport() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "lane",
+		Use:   "lane [file]",
 		Short: "Spent hours per lane",
 		Long:  `Spent hours per lines.`,
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return laneReport()
+			if err := setFileArg(cmd, args); err != nil {
+				return err
+			}
+			settings, err := resolveSettings(cmd, reportLane)
+			if err != nil {
+				return err
+			}
+
+			return runSingleReport(settings)
 		},
 	}
 
+	addReportFlags(cmd, true)
+	cmd.Flags().String("header", defaultLaneHeader, "Label of the cell above the lane tags, in the tag column.")
+	cmd.Flags().String("value-column", "", "What column to write the hours to (default right of the tags)")
+	cmd.Flags().String("rules", "", "YAML rules file rolling tags up into lanes")
+	cmd.Flags().String("separator", "", "Separator of hierarchical tags, e.g. :")
+	cmd.Flags().Int("depth", 0, "Sum hierarchical tags up to this many segments (default all)")
+	cmd.Flags().String("unassigned-lane", "", "Lane collecting the hours of tags without a lane, e.g. Other")
+	cmd.Flags().Bool("strict", false, "Fail if hours of tags without a lane would be lost")
+	cmd.Flags().String("aliases", "", "YAML file mapping lanes to further tags of the input")
+	cmd.Flags().Bool("suggest", false, "Suggest similar lanes for tags without a lane")
+
 	return cmd
 }
 
 func newHoursReport() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "hours",
+		Use:   "hours [file]",
 		Short: "Spent hours per pattern",
 		Long:  `Spent hours per pattern.`,
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			maxEntries, _ := cmd.Flags().GetInt("max-entries")
-			startColumn, _ := cmd.Flags().GetString("start-column")
-
-			startColumnByte := startColumn[0]
-
-			return hoursReport(maxEntries, startColumnByte)
+			if err := setFileArg(cmd, args); err != nil {
+				return err
+			}
+			settings, err := resolveSettings(cmd, reportHours)
+			if err != nil {
+				return err
+			}
+
+			return runSingleReport(settings)
 		},
 	}
 
+	addReportFlags(cmd, true)
 	cmd.Flags().IntP("max-entries", "m", 50, "Max entries to consider.")
 	cmd.Flags().StringP("start-column", "c", "G", "What column to write entries to.")
+	cmd.Flags().String("rules", "", "YAML rules file rolling tags up into lanes")
+	cmd.Flags().String("separator", "", "Separator of hierarchical tags, e.g. :")
+	cmd.Flags().Int("depth", 0, "Sum hierarchical tags up to this many segments (default all)")
+	cmd.Flags().Bool("outline", false, "Write hierarchical tags as an outline with subtotals and row groups")
 
 	return cmd
 }
@@ -110,100 +190,96 @@ func newLastRunTimestamp() *cobra.Command {
 		Short: "Write timestamp of last run",
 		Long:  `Write the current time as timestamp into the sheet so we're aware when the tool ran the last time`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return lastRunTimestamp()
+			settings, err := resolveSettings(cmd, reportLastRunTimestamp)
+			if err != nil {
+				return err
+			}
+
+			return runSingleReport(settings)
 		},
 	}
 
+	addReportFlags(cmd, false)
 	cmd.Flags().IntP("max-entries", "m", 50, "Max entries to consider.")
 	cmd.Flags().StringP("start-column", "c", "G", "What column to write entries to.")
 
 	return cmd
 }
 
-func laneReport() error {
-	client, err := NewClient()
+// Authenticates and runs a single report.
+func runSingleReport(settings reportSettings) error {
+	srv, err := settings.sheetsService()
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
-	filename := os.Getenv("FILE")
-	if len(filename) < 1 {
-		log.Fatalf("Environment variable FILE must be set.")
-	}
+	return runReport(srv, settings)
+}
 
-	hoursByTag, err := ParseLanesFile(filename)
+// Runs the report the settings belong to.
+func runReport(srv *sheets.Service, settings reportSettings) error {
+	rep, err := newReport(srv, settings)
 	if err != nil {
-		log.Fatalf("Failed to parse CSV file with hours per tag: %v", err)
+		return err
 	}
 
-	tabId := os.Getenv("TAB_ID")
-	if len(tabId) < 1 {
-		log.Fatalf("Environment variable TAB_ID must be set.")
+	if reportOpts.dryRun {
+		return dryRun(rep, settings, srv, reportOpts.output, os.Stdout)
 	}
+	return rep.Update()
+}
 
-	// https://docs.google.com/spreadsheets/d/1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms/edit
-	// -> spreadsheetId = 1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms
-	spreadsheetId := os.Getenv("SPREADSHEET_ID")
-	if len(spreadsheetId) < 1 {
-		log.Fatalf("SPREADSHEET_ID not set")
+// Returns the report the settings belong to.
+func newReport(srv *sheets.Service, settings reportSettings) (report, error) {
+	switch settings.name {
+	case reportLane:
+		return laneReport(srv, settings)
+	case reportHours:
+		return hoursReport(srv, settings)
+	case reportLastRunTimestamp:
+		return lastRunTimestamp(srv, settings)
+	default:
+		return nil, fmt.Errorf("Unknown report %q", settings.name)
 	}
-
-	report := NewLaneReport(spreadsheetId, client, hoursByTag, tabId)
-	return report.Update()
 }
 
-func hoursReport(maxEntries int, startColumn byte) error {
-	client, err := NewClient()
+func laneReport(srv *sheets.Service, settings reportSettings) (report, error) {
+	hoursByTag, err := ParseLanesFile(settings.File, settings.inputOptions(), settings.Separator)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, fmt.Errorf("Failed to parse input file: %v", err)
 	}
 
-	filename := os.Getenv("FILE")
-	if len(filename) < 1 {
-		log.Fatalf("Environment variable FILE must be set.")
-	}
-
-	hoursByTag, err := ParseHoursFile(filename)
+	rules, err := loadRules(settings.Rules)
 	if err != nil {
-		log.Fatalf("Failed to parse CSV file with hours per tag: %v", err)
-	}
-
-	tabId := os.Getenv("TAB_ID")
-	if len(tabId) < 1 {
-		log.Fatalf("Environment variable TAB_ID must be set.")
+		return nil, err
 	}
+	hoursByTag = rollUpLanes(rules.applyToLanes(hoursByTag), settings.Separator, intValue(settings.Depth))
 
-	// https://docs.google.com/spreadsheets/d/1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms/edit
-	// -> spreadsheetId = 1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms
-	spreadsheetId := os.Getenv("SPREADSHEET_ID")
-	if len(spreadsheetId) < 1 {
-		log.Fatalf("SPREADSHEET_ID not set")
+	options, err := settings.laneOptions()
+	if err != nil {
+		return nil, err
 	}
 
-	report := NewHoursReport(spreadsheetId, client, hoursByTag, tabId, maxEntries, startColumn)
-	return report.Update()
+	return NewLaneReport(settings.Spreadsheet, srv, hoursByTag, settings.Tab, settings.laneLayout(), options), nil
 }
 
-func lastRunTimestamp() error {
-	client, err := NewClient()
+func hoursReport(srv *sheets.Service, settings reportSettings) (report, error) {
+	hoursByTag, err := ParseInputFile(settings.File, settings.inputOptions(), settings.Separator)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, fmt.Errorf("Failed to parse input file: %v", err)
 	}
 
-	tabId := os.Getenv("TAB_ID")
-	if len(tabId) < 1 {
-		log.Fatalf("Environment variable TAB_ID must be set.")
+	rules, err := loadRules(settings.Rules)
+	if err != nil {
+		return nil, err
 	}
+	hoursByTag = rollUpEntries(rules.applyToEntries(hoursByTag), settings.Separator, intValue(settings.Depth))
 
-	// https://docs.google.com/spreadsheets/d/1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms/edit
-	// -> spreadsheetId = 1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms
-	spreadsheetId := os.Getenv("SPREADSHEET_ID")
-	if len(spreadsheetId) < 1 {
-		log.Fatalf("SPREADSHEET_ID not set")
-	}
+	return NewHoursReport(settings.Spreadsheet, srv, hoursByTag, settings.Tab, settings.hoursLayout(), settings.hoursOptions()), nil
+}
 
-	report := NewLastRunTimestampReport(spreadsheetId, client, tabId)
-	return report.Update()
+func lastRunTimestamp(srv *sheets.Service, settings reportSettings) (report, error) {
+	return NewLastRunTimestampReport(settings.Spreadsheet, srv, settings.Tab, settings.timestampCell(), settings.anchor(), reportOpts.output), nil
 }
 
 func main() {
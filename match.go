@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+	"gopkg.in/yaml.v2"
+)
+
+// tagMatcher decides which lane a tag of the input belongs to. Tags are
+// compared after normalizeTag, and aliases map further source tags to a
+// lane:
+//
+//	Support:
+//	  - ops-support
+//	  - helpdesk
+//	Development: [dev, backend]
+type tagMatcher struct {
+	// aliases maps normalized source tags to the normalized lane.
+	aliases map[string]string
+}
+
+// Trims whitespace, folds case and applies Unicode NFC, so "Support",
+// "support " and "SUPPORT" are the same tag.
+func normalizeTag(tag string) string {
+	return norm.NFC.String(strings.ToLower(strings.TrimSpace(tag)))
+}
+
+// Reads an alias file of lanes and their source tags. An empty path returns
+// a matcher without aliases.
+func loadTagMatcher(path string) (tagMatcher, error) {
+	m := tagMatcher{aliases: map[string]string{}}
+	if len(path) < 1 {
+		return m, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("Unable to read alias file: %v", err)
+	}
+
+	lanes := map[string][]string{}
+	if err := yaml.UnmarshalStrict(b, &lanes); err != nil {
+		return m, fmt.Errorf("Unable to parse alias file %s: %v", path, err)
+	}
+
+	for lane, sources := range lanes {
+		for _, source := range sources {
+			key := normalizeTag(source)
+			if other, ok := m.aliases[key]; ok && other != normalizeTag(lane) {
+				return m, fmt.Errorf("Alias file %s maps %q to more than one lane", path, source)
+			}
+			m.aliases[key] = normalizeTag(lane)
+		}
+	}
+	return m, nil
+}
+
+// Returns the key of the lane a tag of the input counts for.
+func (m tagMatcher) key(tag string) string {
+	key := normalizeTag(tag)
+	if lane, ok := m.aliases[key]; ok {
+		return lane
+	}
+	return key
+}
+
+// Returns the lane closest to tag, or "" if none is close enough to be a
+// likely typo.
+func suggestLane(tag string, lanes []string) string {
+	key := normalizeTag(tag)
+	maxDistance := len([]rune(key)) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	best, bestDistance := "", maxDistance+1
+	for _, lane := range lanes {
+		if d := levenshtein(key, normalizeTag(lane)); d < bestDistance {
+			best, bestDistance = lane, d
+		}
+	}
+	return best
+}
+
+// Returns the number of single rune insertions, deletions and substitutions
+// turning a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{tag: "Support", want: "support"},
+		{tag: "  support \t", want: "support"},
+		{tag: "SUPPORT", want: "support"},
+		// A decomposed é, as macOS file names and some exports have it, is
+		// the same as the precomposed one.
+		{tag: "Cafe\u0301", want: "caf\u00e9"},
+		{tag: "Caf\u00e9", want: "caf\u00e9"},
+		{tag: "CAFE\u0301", want: "caf\u00e9"},
+		{tag: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeTag(tt.tag); got != tt.want {
+			t.Errorf("normalizeTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTagMatcher(t *testing.T) {
+	// The lane Café is written precomposed, its alias decomposed.
+	m, err := loadTagMatcher(writeRules(t, "Support:\n  - ops-support\n  - \" Helpdesk\"\nDevelopment: [dev, backend]\nCaf\u00e9: [coffee, \"kaffe\u0301\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{tag: "ops-support", want: "support"},
+		{tag: "HELPDESK", want: "support"},
+		{tag: "backend", want: "development"},
+		{tag: "Support", want: "support"},
+		{tag: "coffee", want: "caf\u00e9"},
+		{tag: "Cafe\u0301", want: "caf\u00e9"},
+		{tag: "Kaff\u00e9", want: "caf\u00e9"},
+		{tag: "frontend", want: "frontend"},
+	}
+	for _, tt := range tests {
+		if got := m.key(tt.tag); got != tt.want {
+			t.Errorf("key(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTagMatcherErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "alias of two lanes", content: "Support: [helpdesk]\nOps: [Helpdesk]\n", err: "more than one lane"},
+		{name: "not a map of lists", content: "Support: helpdesk\n", err: "Unable to parse"},
+	}
+	for _, tt := range tests {
+		_, err := loadTagMatcher(writeRules(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	// The same alias listed twice for one lane is fine.
+	if _, err := loadTagMatcher(writeRules(t, "Support: [helpdesk, Helpdesk]\n")); err != nil {
+		t.Errorf("repeated alias of one lane: %v", err)
+	}
+}
+
+func TestSuggestLane(t *testing.T) {
+	lanes := []string{"Support", "Development", "Review"}
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{tag: "suport", want: "Support"},
+		{tag: "Developmnet", want: "Development"},
+		{tag: "revew ", want: "Review"},
+		{tag: "marketing", want: ""},
+		{tag: "ops", want: ""},
+	}
+	for _, tt := range tests {
+		if got := suggestLane(tt.tag, lanes); got != tt.want {
+			t.Errorf("suggestLane(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "", b: "abc", want: 3},
+		{a: "kitten", b: "sitting", want: 3},
+		{a: "support", b: "suport", want: 1},
+		{a: "caf\u00e9", b: "cafe", want: 1},
+		{a: "same", b: "same", want: 0},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := levenshtein(tt.b, tt.a); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
@@ -1,119 +1,478 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"net/http"
+	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gogolok/gsheet-updater/internal/a1"
+	log "github.com/sirupsen/logrus"
 	"google.golang.org/api/sheets/v4"
 )
 
+// report is implemented by every report. Plan computes the values to write
+// without writing them, Update writes them.
+type report interface {
+	Plan() (*writePlan, error)
+	Update() error
+}
+
 type reportBase struct {
 	spreadsheetId string
-	client        *http.Client
+	srv           *sheets.Service
+}
+
+// laneLayout locates the lane table. The tags are read from the anchor, or
+// from below the cell labelled header, down to the first empty row. Without
+// either, or with fallback set and no header in the sheet, the tags are read
+// from rows cells of tagColumn starting at firstRow. The hours are written to
+// valueColumn of the same rows, or right of the tags if it is empty.
+type laneLayout struct {
+	anchor      anchor
+	header      string
+	fallback    bool
+	tagColumn   string
+	valueColumn string
+	firstRow    int
+	rows        int
+}
+
+// defaultLaneHeader labels the lane table.
+const defaultLaneHeader = "Lane"
+
+func defaultLaneLayout() laneLayout {
+	return laneLayout{
+		header:    defaultLaneHeader,
+		fallback:  true,
+		tagColumn: "A",
+		firstRow:  4,
+		rows:      11,
+	}
+}
+
+// laneOptions control what happens to hours of tags without a lane.
+type laneOptions struct {
+	// unassignedLane is the tag of the lane collecting the hours of tags
+	// without a lane, e.g. "Other". Empty drops these hours.
+	unassignedLane string
+	// strict fails the report if hours would be dropped.
+	strict bool
+	// matcher maps the tags of the input to lanes.
+	matcher tagMatcher
+	// suggest looks for a similar lane for every unmatched tag.
+	suggest bool
+	// output selects text or json output.
+	output string
 }
 
 type LaneReport struct {
 	reportBase
 	hoursByTag map[string]float64
 	tabId      string
+	layout     laneLayout
+	options    laneOptions
 }
 
-func NewLaneReport(spreadsheetId string, client *http.Client, hoursByTag map[string]float64, tabId string) LaneReport {
+func NewLaneReport(spreadsheetId string, srv *sheets.Service, hoursByTag map[string]float64, tabId string, layout laneLayout, options laneOptions) LaneReport {
 	return LaneReport{
 		reportBase: reportBase{
 			spreadsheetId: spreadsheetId,
-			client:        client,
+			srv:           srv,
 		},
 		hoursByTag: hoursByTag,
 		tabId:      tabId,
+		layout:     layout,
+		options:    options,
 	}
 }
 
-func (r LaneReport) Update() error {
-	srv, err := sheets.New(r.client)
-	if err != nil {
-		return err
-	}
+// laneRow is the planned value of one lane.
+type laneRow struct {
+	tag   string
+	hours float64
+}
 
-	// Location of the tag cells
-	rowOffset := 4
-	noTags := 11
-	cellRange := fmt.Sprintf("A%v:A%v", rowOffset, rowOffset+noTags-1)
-	readRange := fmt.Sprintf("%s!%s", r.tabId, cellRange)
+// Plan reads the lane tags and returns the hours to write next to them.
+func (r LaneReport) Plan() (*writePlan, error) {
+	plan, _, err := r.plan()
+	return plan, err
+}
 
-	resp, err := srv.Spreadsheets.Values.Get(r.spreadsheetId, readRange).Do()
+func (r LaneReport) plan() (*writePlan, []laneRow, error) {
+	tags, tagValues, err := r.findTable()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	if len(resp.Values) == 0 {
-		return fmt.Errorf("No data found in sheet.")
+	if len(tagValues) == 0 {
+		return nil, nil, fmt.Errorf("No data found in sheet.")
 	}
 
-	for idx, row := range resp.Values {
+	// Tags of the lanes by row, empty for rows without a tag
+	laneTags := make([]string, len(tagValues))
+	lanes := map[string]bool{}
+	for idx, row := range tagValues {
+		if len(row) == 0 || row[0] == "" {
+			continue
+		}
 		tag, ok := row[0].(string)
 		if !ok {
-			return fmt.Errorf("Tag must be of type string.")
+			return nil, nil, fmt.Errorf("Tag must be of type string.")
 		}
+		laneTags[idx] = tag
+		lanes[normalizeTag(tag)] = true
+	}
 
-		hours, ok := r.hoursByTag[tag]
-		if !ok {
-			hours = 0.0
+	// Hours per lane, summed over the tags matching it
+	hoursByLane := map[string]float64{}
+	for tag, hours := range r.hoursByTag {
+		hoursByLane[r.options.matcher.key(tag)] += hours
+	}
+
+	plan := &writePlan{inputOption: "RAW"}
+	plan.unmatched = unmatchedTags(r.hoursByTag, lanes, r.options.matcher)
+	if r.options.suggest {
+		for idx := range plan.unmatched {
+			plan.unmatched[idx].Suggestion = suggestLane(plan.unmatched[idx].Tag, laneTags)
+		}
+	}
+	if len(r.options.unassignedLane) > 0 && lanes[normalizeTag(r.options.unassignedLane)] {
+		for _, tag := range laneTags {
+			if len(plan.unassigned) < 1 && normalizeTag(tag) == normalizeTag(r.options.unassignedLane) {
+				plan.unassigned = tag
+			}
+		}
+	} else if len(r.options.unassignedLane) > 0 {
+		log.Warnf("No lane %q for the hours of unmatched tags in %s", r.options.unassignedLane, r.tabId)
+	}
+	if r.options.strict && len(plan.unassigned) < 1 {
+		if lost := lostHours(plan.unmatched); len(lost) > 0 {
+			return nil, nil, fmt.Errorf("%d tag(s) have no lane, their hours would be lost: %s", len(lost), strings.Join(lost, ", "))
+		}
+	}
+
+	// The hours of all lanes go into one range next to the tags, so the
+	// whole table is written at once or not at all. Rows without a tag are
+	// left untouched.
+	values := make([][]interface{}, len(tagValues))
+	rows := []laneRow{}
+	for idx, tag := range laneTags {
+		if len(tag) < 1 {
+			values[idx] = []interface{}{nil}
+			continue
+		}
+
+		hours := hoursByLane[normalizeTag(tag)]
+		if tag == plan.unassigned {
+			for _, u := range plan.unmatched {
+				hours += u.Hours
+			}
 		}
 
-		// One cell right we write the values for the cells
-		var vr sheets.ValueRange
-		myval := []interface{}{hours}
-		vr.Values = append(vr.Values, myval)
-		writeRange := fmt.Sprintf("%s!B%d", r.tabId, idx+rowOffset)
-		_, err := srv.Spreadsheets.Values.Update(r.spreadsheetId, writeRange, &vr).ValueInputOption("RAW").Do()
+		values[idx] = []interface{}{hours}
+		rows = append(rows, laneRow{tag: tag, hours: hours})
+	}
+
+	// By default we write the values one cell right of the tags
+	valueCol := tags.StartCol + 1
+	if len(r.layout.valueColumn) > 0 {
+		valueCol, err = a1.ColumnIndex(r.layout.valueColumn)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
+	}
+	writeRange := a1.Range{
+		Sheet:    tags.Sheet,
+		StartRow: tags.StartRow,
+		StartCol: valueCol,
+		EndRow:   tags.StartRow + len(values) - 1,
+		EndCol:   valueCol,
+	}
+
+	plan.add(writeRange.String(), values)
+
+	return plan, rows, nil
+}
+
+// unmatchedTag is a tag of the input without a lane in the sheet.
+type unmatchedTag struct {
+	Tag        string  `json:"tag"`
+	Hours      float64 `json:"hours"`
+	Suggestion string  `json:"suggestion,omitempty"`
+}
 
-		fmt.Printf("%v: %v %v\n", idx, tag, hours)
+// Returns the tags without a lane, sorted by tag. lanes holds the normalized
+// tags of the lanes.
+func unmatchedTags(hoursByTag map[string]float64, lanes map[string]bool, matcher tagMatcher) []unmatchedTag {
+	unmatched := []unmatchedTag{}
+	for tag, hours := range hoursByTag {
+		if !lanes[matcher.key(tag)] {
+			unmatched = append(unmatched, unmatchedTag{Tag: tag, Hours: hours})
+		}
 	}
+	sort.Slice(unmatched, func(i, j int) bool {
+		return unmatched[i].Tag < unmatched[j].Tag
+	})
+	return unmatched
+}
+
+// Returns the unmatched tags that have hours.
+func lostHours(unmatched []unmatchedTag) []string {
+	lost := []string{}
+	for _, u := range unmatched {
+		if u.Hours != 0 {
+			lost = append(lost, u.Tag)
+		}
+	}
+	return lost
+}
+
+// Returns the range of the tag cells and their values.
+func (r LaneReport) findTable() (a1.Range, [][]interface{}, error) {
+	tagCol, err := a1.ColumnIndex(r.layout.tagColumn)
+	if err != nil {
+		return a1.Range{}, nil, err
+	}
+
+	if r.layout.anchor.isSet() {
+		anchored, err := r.layout.anchor.resolve(r.srv, r.spreadsheetId)
+		if err != nil {
+			return a1.Range{}, nil, err
+		}
+		// The anchor only fixes the first tag, the table may grow past the
+		// end of the anchored range like below a header.
+		start := anchoredCell(anchored, a1.Cell(r.tabId, r.layout.firstRow-1, tagCol))
+		column := a1.Range{
+			Sheet:    start.Sheet,
+			StartRow: start.StartRow,
+			StartCol: start.StartCol,
+			EndRow:   a1.Unbounded,
+			EndCol:   start.StartCol,
+		}
+
+		resp, err := r.srv.Spreadsheets.Values.Get(r.spreadsheetId, column.String()).Do()
+		if err != nil {
+			return a1.Range{}, nil, err
+		}
+		values := untilEmptyRow(resp.Values)
+		tags := column
+		tags.EndRow = start.StartRow + len(values) - 1
+		return tags, values, nil
+	}
+
+	if len(r.layout.header) > 0 {
+		tags, values, found, err := r.findHeader()
+		if err != nil {
+			return a1.Range{}, nil, err
+		}
+		if found {
+			return tags, values, nil
+		}
+		if !r.layout.fallback {
+			return a1.Range{}, nil, fmt.Errorf("No cell labelled %q found in %s.", r.layout.header, r.tabId)
+		}
+		log.Infof("No cell labelled %q found in %s, reading the tags from %s%d", r.layout.header, r.tabId, r.layout.tagColumn, r.layout.firstRow)
+	}
+
+	// Location of the tag cells
+	tags := a1.Range{
+		Sheet:    r.tabId,
+		StartRow: r.layout.firstRow - 1,
+		StartCol: tagCol,
+		EndRow:   r.layout.firstRow + r.layout.rows - 2,
+		EndCol:   tagCol,
+	}
+
+	resp, err := r.srv.Spreadsheets.Values.Get(r.spreadsheetId, tags.String()).Do()
+	if err != nil {
+		return a1.Range{}, nil, err
+	}
+	return tags, resp.Values, nil
+}
+
+// Searches the tag column for the cell holding exactly the header and
+// returns the tags below it.
+func (r LaneReport) findHeader() (a1.Range, [][]interface{}, bool, error) {
+	tagCol, err := a1.ColumnIndex(r.layout.tagColumn)
+	if err != nil {
+		return a1.Range{}, nil, false, err
+	}
+	column := a1.Range{Sheet: r.tabId, StartRow: 0, StartCol: tagCol, EndRow: a1.Unbounded, EndCol: tagCol}
+
+	resp, err := r.srv.Spreadsheets.Values.Get(r.spreadsheetId, column.String()).Do()
+	if err != nil {
+		return a1.Range{}, nil, false, err
+	}
+	read, err := a1.Parse(resp.Range)
+	if err != nil {
+		return a1.Range{}, nil, false, err
+	}
+
+	for i, row := range resp.Values {
+		if len(row) == 0 {
+			continue
+		}
+		if label, ok := row[0].(string); !ok || strings.TrimSpace(label) != r.layout.header {
+			continue
+		}
+
+		values := untilEmptyRow(resp.Values[i+1:])
+		tags := a1.Range{
+			Sheet:    r.tabId,
+			StartRow: read.StartRow + i + 1,
+			StartCol: tagCol,
+			EndRow:   read.StartRow + i + len(values),
+			EndCol:   tagCol,
+		}
+		return tags, values, true, nil
+	}
+	return a1.Range{}, nil, false, nil
+}
+
+// Returns the rows up to the first one without a value in its first cell.
+func untilEmptyRow(values [][]interface{}) [][]interface{} {
+	for idx, row := range values {
+		if len(row) == 0 || row[0] == "" {
+			return values[:idx]
+		}
+	}
+	return values
+}
+
+func (r LaneReport) Update() error {
+	plan, rows, err := r.plan()
+	if err != nil {
+		return err
+	}
+
+	changes, err := applyPlan(r.srv, r.spreadsheetId, plan)
+	if err != nil {
+		return err
+	}
+
+	if r.options.output == outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(newPlanDiff(reportLane, r.spreadsheetId, len(rows), changes, plan))
+	}
+
+	for idx, row := range rows {
+		fmt.Printf("%v: %v %v\n", idx, row.tag, row.hours)
+	}
+	fmt.Printf("%d of %d cells changed\n", len(changes), len(rows))
+	printUnmatched(os.Stdout, plan.unmatched, plan.unassigned)
 
 	return nil
 }
 
+// hoursLayout locates the hours block: tags go to startColumn and hours to
+// the column right of it, starting at firstRow. The anchor, if set, replaces
+// the position of the first tag.
+type hoursLayout struct {
+	anchor      anchor
+	startColumn string
+	firstRow    int
+	maxEntries  int
+}
+
+func defaultHoursLayout() hoursLayout {
+	return hoursLayout{
+		startColumn: "G",
+		firstRow:    19,
+		maxEntries:  50,
+	}
+}
+
+// hoursOptions control how hierarchical tags are shown.
+type hoursOptions struct {
+	// separator splits tags into a hierarchy, e.g. ":".
+	separator string
+	// outline writes the tag tree indented, with subtotals per parent and
+	// its children grouped below it.
+	outline bool
+	// output selects text or json output.
+	output string
+}
+
 type HoursReport struct {
 	reportBase
-	entries     []hourTagEntry
-	tabId       string
-	maxEntries  int
-	startColumn byte
+	entries []hourTagEntry
+	tabId   string
+	layout  hoursLayout
+	options hoursOptions
 }
 
-func NewHoursReport(spreadsheetId string, client *http.Client, entries []hourTagEntry, tabId string, maxEntries int, startColumn byte) HoursReport {
+func NewHoursReport(spreadsheetId string, srv *sheets.Service, entries []hourTagEntry, tabId string, layout hoursLayout, options hoursOptions) HoursReport {
 	return HoursReport{
 		reportBase: reportBase{
 			spreadsheetId: spreadsheetId,
-			client:        client,
+			srv:           srv,
 		},
-		entries:     entries,
-		tabId:       tabId,
-		maxEntries:  maxEntries,
-		startColumn: startColumn,
+		entries: entries,
+		tabId:   tabId,
+		layout:  layout,
+		options: options,
 	}
 }
 
-func (r HoursReport) Update() error {
-	srv, err := sheets.New(r.client)
+// Plan returns the block of tags and hours, sorted by hours.
+func (r HoursReport) Plan() (*writePlan, error) {
+	rowOffset := r.layout.firstRow // Location of the cells
+	maxEntries := r.layout.maxEntries
+
+	var values [][]interface{}
+	var groups []rowGroup
+	if r.options.outline {
+		values, groups = r.outlineValues()
+	} else {
+		values = r.flatValues()
+	}
+
+	startColumn, err := a1.ColumnIndex(r.layout.startColumn)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	start := a1.Cell(r.tabId, rowOffset-1, startColumn)
+	if r.layout.anchor.isSet() {
+		anchored, err := r.layout.anchor.resolve(r.srv, r.spreadsheetId)
+		if err != nil {
+			return nil, err
+		}
+		start = anchoredCell(anchored, start)
 	}
 
-	sort.Sort(sort.Reverse(hoursSortedEntries(r.entries)))
+	rangeData := a1.Range{
+		Sheet:    start.Sheet,
+		StartRow: start.StartRow,
+		StartCol: start.StartCol,
+		EndRow:   start.StartRow + maxEntries,
+		EndCol:   start.StartCol + 1,
+	}
+	plan := &writePlan{inputOption: "USER_ENTERED"}
+	plan.add(rangeData.String(), values)
+
+	if r.options.outline {
+		plan.outline = &rowOutline{
+			sheet: start.Sheet,
+			start: start.StartRow,
+			end:   start.StartRow + maxEntries,
+		}
+		for _, g := range groups {
+			plan.outline.groups = append(plan.outline.groups, rowGroup{start: start.StartRow + g.start, end: start.StartRow + g.end})
+		}
+	}
+	return plan, nil
+}
 
-	rowOffset := 19 // Location of the cells
+// Returns maxEntries rows of tag and hours, sorted by hours.
+func (r HoursReport) flatValues() [][]interface{} {
+	sort.Sort(sort.Reverse(hoursSortedEntries(r.entries)))
 
 	entriesLen := len(r.entries)
 	values := [][]interface{}{}
-	for idx := 0; idx < r.maxEntries; idx++ {
+	for idx := 0; idx < r.layout.maxEntries; idx++ {
 		tag := ""
 		hours := ""
 		if idx < entriesLen {
@@ -122,59 +481,133 @@ func (r HoursReport) Update() error {
 		}
 
 		values = append(values, []interface{}{tag, hours})
-		fmt.Printf("%v: %v %v\n", idx, tag, hours)
 	}
+	return values
+}
 
-	secondColumn := int(r.startColumn) + 1
+// Returns maxEntries rows of the tag tree with the total hours of every
+// node, and the groups of rows below each parent.
+func (r HoursReport) outlineValues() ([][]interface{}, []rowGroup) {
+	rows := buildTagTree(r.entries, r.options.separator).outline()
 
-	rangeData := fmt.Sprintf("%s!%s%d:%s%d", r.tabId, string(r.startColumn), rowOffset, string(secondColumn), rowOffset+r.maxEntries)
-	rb := &sheets.BatchUpdateValuesRequest{
-		ValueInputOption: "USER_ENTERED",
+	values := [][]interface{}{}
+	groups := []rowGroup{}
+	for idx := 0; idx < r.layout.maxEntries; idx++ {
+		if idx >= len(rows) {
+			values = append(values, []interface{}{"", ""})
+			continue
+		}
+
+		row := rows[idx]
+		tag := strings.Repeat("  ", row.level) + row.node.name
+		values = append(values, []interface{}{tag, strconv.FormatFloat(row.node.total, 'f', 2, 64)})
+
+		end := row.end
+		if end > r.layout.maxEntries {
+			end = r.layout.maxEntries
+		}
+		if end > idx+1 {
+			groups = append(groups, rowGroup{start: idx + 1, end: end})
+		}
+	}
+	return values, groups
+}
+
+func (r HoursReport) Update() error {
+	plan, err := r.Plan()
+	if err != nil {
+		return err
 	}
-	rb.Data = append(rb.Data, &sheets.ValueRange{
-		Range:  rangeData,
-		Values: values,
-	})
 
-	_, err = srv.Spreadsheets.Values.BatchUpdate(r.spreadsheetId, rb).Do()
-	return err
+	changes, err := applyPlan(r.srv, r.spreadsheetId, plan)
+	if err != nil {
+		return err
+	}
+	if plan.outline != nil {
+		if err := syncRowGroups(r.srv, r.spreadsheetId, plan.outline); err != nil {
+			return err
+		}
+	}
+
+	values := plan.data[0].Values
+	if r.options.output == outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(newPlanDiff(reportHours, r.spreadsheetId, 2*len(values), changes, plan))
+	}
+	for idx, row := range values {
+		fmt.Printf("%v: %v %v\n", idx, row[0], row[1])
+	}
+	fmt.Printf("%d of %d cells changed\n", len(changes), 2*len(values))
+
+	return nil
 }
 
+// defaultTimestampCell is where the time of the last run is written.
+const defaultTimestampCell = "D2"
+
 type LastRunTimestampReport struct {
 	reportBase
-	tabId string
+	tabId  string
+	cell   string
+	anchor anchor
+	// output selects text or json output.
+	output string
 }
 
-func NewLastRunTimestampReport(spreadsheetId string, client *http.Client, tabId string) LastRunTimestampReport {
+// NewLastRunTimestampReport writes to cell, or to the cell the anchor points
+// to if it is set.
+func NewLastRunTimestampReport(spreadsheetId string, srv *sheets.Service, tabId string, cell string, anchor anchor, output string) LastRunTimestampReport {
 	return LastRunTimestampReport{
 		reportBase: reportBase{
 			spreadsheetId: spreadsheetId,
-			client:        client,
+			srv:           srv,
 		},
-		tabId: tabId,
+		tabId:  tabId,
+		cell:   cell,
+		anchor: anchor,
+		output: output,
 	}
 }
 
-func (r LastRunTimestampReport) Update() error {
+// Plan returns the current time for the timestamp cell.
+func (r LastRunTimestampReport) Plan() (*writePlan, error) {
 	now := time.Now()
 	loc, _ := time.LoadLocation("Europe/Berlin")
 	timestamp := now.In(loc)
 
-	srv, err := sheets.New(r.client)
+	cell, err := a1.Parse(r.cell)
+	if err != nil {
+		return nil, err
+	}
+	cell.Sheet = r.tabId
+	if r.anchor.isSet() {
+		anchored, err := r.anchor.resolve(r.srv, r.spreadsheetId)
+		if err != nil {
+			return nil, err
+		}
+		cell = anchoredCell(anchored, cell)
+	}
+
+	plan := &writePlan{inputOption: "RAW"}
+	plan.add(cell.String(), [][]interface{}{{timestamp}})
+	return plan, nil
+}
+
+func (r LastRunTimestampReport) Update() error {
+	plan, err := r.Plan()
 	if err != nil {
 		return err
 	}
 
-	var vr sheets.ValueRange
-	myval := []interface{}{timestamp}
-	vr.Values = append(vr.Values, myval)
-	writeRange := fmt.Sprintf("%s!D2", r.tabId)
-	_, err = srv.Spreadsheets.Values.Update(r.spreadsheetId, writeRange, &vr).ValueInputOption("RAW").Do()
+	changes, err := applyPlan(r.srv, r.spreadsheetId, plan)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("D2 %v\n", timestamp)
+	if r.output == outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(newPlanDiff(reportLastRunTimestamp, r.spreadsheetId, 1, changes, plan))
+	}
+	vr := plan.data[0]
+	fmt.Printf("%s %v\n", vr.Range, vr.Values[0][0])
 
 	return nil
 }
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogolok/gsheet-updater/internal/a1"
+	"github.com/gogolok/gsheet-updater/internal/fakesheets"
+	"google.golang.org/api/sheets/v4"
+)
+
+const testSpreadsheet = "sheet-1"
+
+// Returns a fake Sheets server with the tab "Sprint" and a sheets service
+// pointed at it.
+func newTestSheets(t *testing.T) (*fakesheets.Server, *sheets.Service) {
+	t.Helper()
+	fake := fakesheets.New()
+	fake.AddSheet(testSpreadsheet, "Sprint")
+	return fake, newTestService(t, fake)
+}
+
+// Returns a sheets service sending its requests to handler.
+func newTestService(t *testing.T, handler http.Handler) *sheets.Service {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	service, err := NewSheetsService(http.DefaultClient, &clientOptions{apiEndpoint: srv.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+// batchRecorder passes requests on to the fake and keeps the values.batchUpdate
+// requests, after edit changed them if it is set.
+type batchRecorder struct {
+	fake *fakesheets.Server
+	edit func(*sheets.BatchUpdateValuesRequest)
+	sent []*sheets.BatchUpdateValuesRequest
+}
+
+func (r *batchRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/values:batchUpdate") {
+		body := &sheets.BatchUpdateValuesRequest{}
+		if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.edit != nil {
+			r.edit(body)
+		}
+		r.sent = append(r.sent, body)
+
+		b, _ := json.Marshal(body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		req.ContentLength = int64(len(b))
+	}
+	r.fake.ServeHTTP(w, req)
+}
+
+func setValues(t *testing.T, fake *fakesheets.Server, rng string, values [][]interface{}) {
+	t.Helper()
+	if err := fake.SetValues(testSpreadsheet, rng, values); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertValues(t *testing.T, fake *fakesheets.Server, rng string, want [][]interface{}) {
+	t.Helper()
+	got, err := fake.Values(testSpreadsheet, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %v, want %v", rng, got, want)
+	}
+}
+
+func TestLaneReportUpdate(t *testing.T) {
+	fake, service := newTestSheets(t)
+	setValues(t, fake, "Sprint!A3:B7", [][]interface{}{
+		{"Lane", "Hours"},
+		{"Dev", 1.0},
+		{"Review"},
+		{"Other"},
+		{"Ops"},
+	})
+
+	hours := map[string]float64{"dev": 2.75, "review": 0.75, "support": 1.5}
+	options := laneOptions{unassignedLane: "Other"}
+	if err := NewLaneReport(testSpreadsheet, service, hours, "Sprint", defaultLaneLayout(), options).Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertValues(t, fake, "Sprint!B4:B7", [][]interface{}{{2.75}, {0.75}, {1.5}, {0.0}})
+}
+
+func TestLaneReportStrict(t *testing.T) {
+	fake, service := newTestSheets(t)
+	setValues(t, fake, "Sprint!A3:B4", [][]interface{}{{"Lane"}, {"Dev", 1.0}})
+
+	hours := map[string]float64{"dev": 2.75, "support": 1.5}
+	err := NewLaneReport(testSpreadsheet, service, hours, "Sprint", defaultLaneLayout(), laneOptions{strict: true}).Update()
+	if err == nil || !strings.Contains(err.Error(), "support") {
+		t.Fatalf("err = %v, want the lost tag support", err)
+	}
+	assertValues(t, fake, "Sprint!B4", [][]interface{}{{1.0}})
+}
+
+func TestHoursReportUpdate(t *testing.T) {
+	fake, service := newTestSheets(t)
+	setValues(t, fake, "Sprint!G22", [][]interface{}{{"stale"}})
+
+	entries := []hourTagEntry{{Tag: "review", Hours: 0.75}, {Tag: "dev", Hours: 2.75}, {Tag: "ops", Hours: 1.25}}
+	layout := defaultHoursLayout()
+	layout.maxEntries = 4
+	if err := NewHoursReport(testSpreadsheet, service, entries, "Sprint", layout, hoursOptions{}).Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertValues(t, fake, "Sprint!G19:H22", [][]interface{}{
+		{"dev", 2.75},
+		{"ops", 1.25},
+		{"review", 0.75},
+	})
+}
+
+func TestHoursReportOutline(t *testing.T) {
+	fake, service := newTestSheets(t)
+
+	entries := []hourTagEntry{{Tag: "dev:api", Hours: 2}, {Tag: "dev:ui", Hours: 1}, {Tag: "ops", Hours: 0.5}}
+	layout := defaultHoursLayout()
+	layout.maxEntries = 5
+	options := hoursOptions{separator: ":", outline: true}
+	if err := NewHoursReport(testSpreadsheet, service, entries, "Sprint", layout, options).Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fake.Values(testSpreadsheet, "Sprint!G19:G22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[0][0] != "dev" || got[1][0] != "  api" {
+		t.Errorf("outline = %v", got)
+	}
+	if groups := fake.RowGroups(testSpreadsheet, "Sprint"); len(groups) != 1 || groups[0].Range.StartIndex != 19 || groups[0].Range.EndIndex != 21 {
+		t.Errorf("row groups = %v, want rows 20 to 21", groups)
+	}
+}
+
+func TestLastRunTimestampReportUpdate(t *testing.T) {
+	fake, service := newTestSheets(t)
+
+	before := time.Now().Add(-time.Second)
+	if err := NewLastRunTimestampReport(testSpreadsheet, service, "Sprint", defaultTimestampCell, anchor{}, outputText).Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fake.Values(testSpreadsheet, "Sprint!D2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || len(got[0]) != 1 {
+		t.Fatalf("D2 = %v", got)
+	}
+	written, ok := got[0][0].(string)
+	if !ok {
+		t.Fatalf("D2 = %#v, want a timestamp", got[0][0])
+	}
+	ts, err := time.Parse(time.RFC3339Nano, written)
+	if err != nil || ts.Before(before) {
+		t.Errorf("D2 = %q, want the time of the run", written)
+	}
+}
+
+func TestFakeBatchUpdateIsAtomic(t *testing.T) {
+	fake, service := newTestSheets(t)
+
+	req := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{
+		{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: "New"}}},
+		{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: "Sprint"}}},
+	}}
+	if _, err := service.Spreadsheets.BatchUpdate(testSpreadsheet, req).Do(); err == nil {
+		t.Fatal("adding a duplicate sheet succeeded")
+	}
+
+	if _, err := fake.Values(testSpreadsheet, "New!A1"); err == nil {
+		t.Error("the failed batch added the sheet New")
+	}
+}
+
+func TestLaneReportFailedWriteChangesNothing(t *testing.T) {
+	fake := fakesheets.New()
+	fake.AddSheet(testSpreadsheet, "Sprint")
+	// The last range of the write points to a sheet removed meanwhile.
+	recorder := &batchRecorder{fake: fake, edit: func(req *sheets.BatchUpdateValuesRequest) {
+		req.Data[len(req.Data)-1].Range = "Removed!B6"
+	}}
+	service := newTestService(t, recorder)
+	setValues(t, fake, "Sprint!A3:B6", [][]interface{}{{"Lane"}, {"Dev", 1.0}, {"Review", 1.0}, {"Ops", 1.0}})
+
+	hours := map[string]float64{"dev": 2.75, "review": 0.75, "ops": 0.5}
+	if err := NewLaneReport(testSpreadsheet, service, hours, "Sprint", defaultLaneLayout(), laneOptions{}).Update(); err == nil {
+		t.Fatal("the write with an invalid range succeeded")
+	}
+
+	if len(recorder.sent) != 1 || len(recorder.sent[0].Data) != 3 {
+		t.Fatalf("sent %v, want one request with the three lanes", recorder.sent)
+	}
+	assertValues(t, fake, "Sprint!A3:B6", [][]interface{}{{"Lane"}, {"Dev", 1.0}, {"Review", 1.0}, {"Ops", 1.0}})
+}
+
+// Returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	return string(b)
+}
+
+func TestReportsPrintJSON(t *testing.T) {
+	fake, service := newTestSheets(t)
+	setValues(t, fake, "Sprint!A3:A4", [][]interface{}{{"Lane"}, {"Dev"}})
+
+	layout := defaultHoursLayout()
+	layout.maxEntries = 2
+	reports := map[string]report{
+		reportLane:             NewLaneReport(testSpreadsheet, service, map[string]float64{"dev": 1}, "Sprint", defaultLaneLayout(), laneOptions{output: outputJSON}),
+		reportHours:            NewHoursReport(testSpreadsheet, service, []hourTagEntry{{Tag: "dev", Hours: 1}}, "Sprint", layout, hoursOptions{output: outputJSON}),
+		reportLastRunTimestamp: NewLastRunTimestampReport(testSpreadsheet, service, "Sprint", defaultTimestampCell, anchor{}, outputJSON),
+	}
+	for name, rep := range reports {
+		out := captureStdout(t, rep.Update)
+
+		var diff planDiff
+		if err := json.Unmarshal([]byte(out), &diff); err != nil {
+			t.Errorf("%s printed %q, not JSON: %v", name, out, err)
+			continue
+		}
+		if diff.Report != name || len(diff.Changes) == 0 {
+			t.Errorf("%s printed %+v", name, diff)
+		}
+	}
+}
+
+func TestLaneReportFindsHeaderOnlyInTagColumn(t *testing.T) {
+	fake, service := newTestSheets(t)
+	setValues(t, fake, "Sprint!A1:D3", [][]interface{}{
+		{"Sprint 25", "", "Lane", "Owner"},
+		{"Lane changes", "", "Fast", "Ann"},
+		{"Lane"},
+	})
+	setValues(t, fake, "Sprint!A4:A5", [][]interface{}{{"Dev"}, {"Ops"}})
+
+	hours := map[string]float64{"dev": 2, "ops": 1, "fast": 5}
+	if err := NewLaneReport(testSpreadsheet, service, hours, "Sprint", defaultLaneLayout(), laneOptions{}).Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertValues(t, fake, "Sprint!B4:B5", [][]interface{}{{2.0}, {1.0}})
+	assertValues(t, fake, "Sprint!D2", [][]interface{}{{"Ann"}})
+}
+
+func TestLaneReportReadsPastAnchoredRange(t *testing.T) {
+	fake, service := newTestSheets(t)
+	setValues(t, fake, "Sprint!C5:C7", [][]interface{}{{"Dev"}, {"Review"}, {"Ops"}})
+	// The named range was made before the lane Ops was added.
+	if err := fake.AddNamedRange(testSpreadsheet, "Lanes", "Sprint!C5:C6"); err != nil {
+		t.Fatal(err)
+	}
+
+	layout := defaultLaneLayout()
+	layout.anchor = anchor{rangeName: "Lanes"}
+	hours := map[string]float64{"dev": 2, "review": 1, "ops": 0.5}
+	if err := NewLaneReport(testSpreadsheet, service, hours, "Sprint", layout, laneOptions{}).Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertValues(t, fake, "Sprint!D5:D8", [][]interface{}{{2.0}, {1.0}, {0.5}})
+}
+
+// Returns the API methods called that write to the sheet.
+func writeCalls(fake *fakesheets.Server) []string {
+	writes := []string{}
+	for _, call := range fake.Calls() {
+		switch call {
+		case "values.update", "values.batchUpdate", "values.append", "spreadsheets.batchUpdate":
+			writes = append(writes, call)
+		}
+	}
+	return writes
+}
+
+func TestDryRun(t *testing.T) {
+	for _, output := range []string{outputText, outputJSON} {
+		t.Run(output, func(t *testing.T) {
+			fake, service := newTestSheets(t)
+			setValues(t, fake, "Sprint!A3:B5", [][]interface{}{{"Lane"}, {"Dev", 1.0}, {"Ops", 0.5}})
+
+			hours := map[string]float64{"dev": 2.75, "ops": 0.5}
+			rep := NewLaneReport(testSpreadsheet, service, hours, "Sprint", defaultLaneLayout(), laneOptions{})
+			settings := reportSettings{name: reportLane, reportConfig: reportConfig{Spreadsheet: testSpreadsheet}}
+			var out strings.Builder
+			if err := dryRun(rep, settings, service, output, &out); err != nil {
+				t.Fatal(err)
+			}
+
+			if writes := writeCalls(fake); len(writes) > 0 {
+				t.Errorf("dry run wrote with %v", writes)
+			}
+			assertValues(t, fake, "Sprint!B4:B5", [][]interface{}{{1.0}, {0.5}})
+
+			if output == outputText {
+				lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+				want := []string{
+					"Dry run of lane: 1 of 2 planned cells change",
+					"CELL       OLD  NEW",
+					"Sprint!B4  1    2.75",
+				}
+				if !reflect.DeepEqual(lines, want) {
+					t.Errorf("printed\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+				}
+				return
+			}
+			var diff planDiff
+			if err := json.Unmarshal([]byte(out.String()), &diff); err != nil {
+				t.Fatalf("printed %q, not JSON: %v", out.String(), err)
+			}
+			want := []cellChange{{Cell: "Sprint!B4", Old: "1", New: "2.75"}}
+			if diff.Report != reportLane || diff.Planned != 2 || !reflect.DeepEqual(diff.Changes, want) {
+				t.Errorf("printed %+v, want changes %+v", diff, want)
+			}
+		})
+	}
+}
+
+// Counts the calls of method.
+func countCalls(fake *fakesheets.Server, method string) int {
+	n := 0
+	for _, call := range fake.Calls() {
+		if call == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLaneReportSkipsUnchangedSheet(t *testing.T) {
+	fake, service := newTestSheets(t)
+	setValues(t, fake, "Sprint!A3:A5", [][]interface{}{{"Lane"}, {"Dev"}, {"Ops"}})
+
+	hours := map[string]float64{"dev": 2.75, "ops": 0.5}
+	for run := 1; run <= 2; run++ {
+		if err := NewLaneReport(testSpreadsheet, service, hours, "Sprint", defaultLaneLayout(), laneOptions{}).Update(); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if writes := countCalls(fake, "values.batchUpdate"); writes != 1 {
+			t.Errorf("after run %d: %d values.batchUpdate calls, want 1", run, writes)
+		}
+	}
+	assertValues(t, fake, "Sprint!B4:B5", [][]interface{}{{2.75}, {0.5}})
+}
+
+func TestLaneReportSendsOnlyChangedCells(t *testing.T) {
+	fake := fakesheets.New()
+	fake.AddSheet(testSpreadsheet, "Sprint")
+	recorder := &batchRecorder{fake: fake}
+	service := newTestService(t, recorder)
+	setValues(t, fake, "Sprint!A3:B6", [][]interface{}{{"Lane"}, {"Dev", 2.75}, {"Review", 1.0}, {"Ops", 0.5}})
+
+	hours := map[string]float64{"dev": 2.75, "review": 0.75, "ops": 0.5}
+	if err := NewLaneReport(testSpreadsheet, service, hours, "Sprint", defaultLaneLayout(), laneOptions{}).Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.sent) != 1 {
+		t.Fatalf("sent %d values.batchUpdate requests, want 1", len(recorder.sent))
+	}
+	data := recorder.sent[0].Data
+	if len(data) != 1 || data[0].Range != "Sprint!B5" || !reflect.DeepEqual(data[0].Values, [][]interface{}{{0.75}}) {
+		t.Errorf("sent %v, want only Sprint!B5 = 0.75", data)
+	}
+	assertValues(t, fake, "Sprint!B4:B6", [][]interface{}{{2.75}, {0.75}, {0.5}})
+}
+
+func TestAnchorResolvesDeveloperMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		rng  string
+		want a1.Range
+	}{
+		{name: "rows", rng: "Sprint!10:11", want: a1.Range{Sheet: "Sprint", StartRow: 9, EndRow: 10, StartCol: 0, EndCol: a1.Unbounded}},
+		{name: "columns", rng: "Sprint!K:K", want: a1.Range{Sheet: "Sprint", StartRow: 0, EndRow: a1.Unbounded, StartCol: 10, EndCol: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, service := newTestSheets(t)
+			if err := fake.AddDeveloperMetadata(testSpreadsheet, "hours-block", "", tt.rng); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := anchor{metadataKey: "hours-block"}.resolve(service, testSpreadsheet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolved %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHoursReportAnchoredToMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		rng  string
+		want string
+	}{
+		// Rows only move the report down, the column stays G.
+		{name: "rows", rng: "Sprint!10:10", want: "Sprint!G10:H11"},
+		// Columns only move the report right, the row stays 19.
+		{name: "columns", rng: "Sprint!K:K", want: "Sprint!K19:L20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, service := newTestSheets(t)
+			if err := fake.AddDeveloperMetadata(testSpreadsheet, "hours-block", "", tt.rng); err != nil {
+				t.Fatal(err)
+			}
+
+			layout := defaultHoursLayout()
+			layout.maxEntries = 2
+			layout.anchor = anchor{metadataKey: "hours-block"}
+			entries := []hourTagEntry{{Tag: "dev", Hours: 2}, {Tag: "ops", Hours: 1}}
+			if err := NewHoursReport(testSpreadsheet, service, entries, "Sprint", layout, hoursOptions{}).Update(); err != nil {
+				t.Fatal(err)
+			}
+
+			assertValues(t, fake, tt.want, [][]interface{}{{"dev", 2.0}, {"ops", 1.0}})
+		})
+	}
+}
+
+func TestAnchorWithoutMatchingMetadata(t *testing.T) {
+	fake, service := newTestSheets(t)
+	if err := fake.AddDeveloperMetadata(testSpreadsheet, "other-block", "", "Sprint!10:10"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := anchor{metadataKey: "hours-block"}.resolve(service, testSpreadsheet)
+	if err == nil || !strings.Contains(err.Error(), "found 0") {
+		t.Errorf("err = %v, want no location found", err)
+	}
+}
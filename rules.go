@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// rulesFile is an ordered list of rules rolling tags of the input up into
+// lanes. The first matching rule wins, tags matching no rule are kept:
+//
+//	rules:
+//	  - glob: ops-*
+//	    lane: Support
+//	  - regex: ^(dev|backend)(:.*)?$
+//	    lane: Development
+type rulesFile struct {
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Glob  string `yaml:"glob"`
+	Regex string `yaml:"regex"`
+	Lane  string `yaml:"lane"`
+
+	re *regexp.Regexp
+}
+
+func (r rule) String() string {
+	if len(r.Glob) > 0 {
+		return "glob " + r.Glob
+	}
+	return "regex " + r.Regex
+}
+
+type tagRules []rule
+
+// Reads and compiles a rules file, reporting every invalid rule at once. An
+// empty path returns no rules.
+func loadRules(path string) (tagRules, error) {
+	if len(path) < 1 {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read rules file: %v", err)
+	}
+
+	f := rulesFile{}
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, fmt.Errorf("Unable to parse rules file %s: %v", path, err)
+	}
+
+	problems := []string{}
+	for idx := range f.Rules {
+		r := &f.Rules[idx]
+		if len(r.Lane) < 1 {
+			problems = append(problems, fmt.Sprintf("rules[%d]: lane is not set", idx))
+		}
+
+		expr := r.Regex
+		switch {
+		case len(r.Glob) > 0 && len(r.Regex) > 0:
+			problems = append(problems, fmt.Sprintf("rules[%d]: glob and regex exclude each other", idx))
+			continue
+		case len(r.Glob) > 0:
+			expr = globRegexp(r.Glob)
+		case len(r.Regex) < 1:
+			problems = append(problems, fmt.Sprintf("rules[%d]: either glob or regex must be set", idx))
+			continue
+		}
+
+		r.re, err = regexp.Compile("(?i)" + expr)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("rules[%d]: %v", idx, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid rules file %s:\n  %s", path, strings.Join(problems, "\n  "))
+	}
+	return tagRules(f.Rules), nil
+}
+
+// Turns a glob into an anchored regular expression. "*" matches any text
+// and "?" a single character.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Returns the lane of the tag and the index of the rule that matched, or
+// the tag itself and -1. Rules match the normalized tag, ignoring case.
+func (rules tagRules) match(tag string) (string, int) {
+	key := normalizeTag(tag)
+	for idx, r := range rules {
+		if r.re.MatchString(key) {
+			return r.Lane, idx
+		}
+	}
+	return tag, -1
+}
+
+// Returns the hours summed per lane.
+func (rules tagRules) applyToLanes(hoursByTag map[string]float64) map[string]float64 {
+	if len(rules) == 0 {
+		return hoursByTag
+	}
+
+	hoursByLane := make(map[string]float64)
+	for tag, hours := range hoursByTag {
+		lane, _ := rules.match(tag)
+		hoursByLane[lane] += hours
+	}
+	return hoursByLane
+}
+
+// Returns one entry per lane with the summed hours, in the order the lanes
+// first appear.
+func (rules tagRules) applyToEntries(entries []hourTagEntry) []hourTagEntry {
+	if len(rules) == 0 {
+		return entries
+	}
+
+	ret := make([]hourTagEntry, 0, len(entries))
+	index := map[string]int{}
+	for _, entry := range entries {
+		lane, _ := rules.match(entry.Tag)
+		if idx, ok := index[lane]; ok {
+			ret[idx].Hours += entry.Hours
+			continue
+		}
+		index[lane] = len(ret)
+		ret = append(ret, hourTagEntry{Tag: lane, Hours: entry.Hours})
+	}
+	return ret
+}
+
+func newRulesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Work with the rules rolling tags up into lanes",
+	}
+
+	var rulesPath string
+	testCmd := &cobra.Command{
+		Use:         "test [tag...]",
+		Short:       "Show which rule each tag matches",
+		Annotations: map[string]string{readOnlyAnnotation: "true"},
+		Long:        `Show the rule and lane each tag matches. The tags are taken from the arguments, or from the input file if none are given. The input file is read like by the lane and hours reports.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rules, err := loadRules(rulesPath)
+			if err != nil {
+				return err
+			}
+
+			tags := args
+			if len(tags) == 0 {
+				if tags, err = inputTags(cmd); err != nil {
+					return err
+				}
+			}
+
+			return printRuleMatches(os.Stdout, rules, tags)
+		},
+	}
+	testCmd.Flags().StringVar(&rulesPath, "rules", "", "YAML rules file")
+	testCmd.Flags().String("separator", "", "Separator of hierarchical tags, e.g. :")
+	addInputFlags(testCmd)
+	testCmd.MarkFlagRequired("rules")
+
+	cmd.AddCommand(testCmd)
+	return cmd
+}
+
+// Returns the sorted tags of the input file selected by the flags and the
+// config file.
+func inputTags(cmd *cobra.Command) ([]string, error) {
+	s, err := settingsFromFlags(cmd, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(s.File) < 1 {
+		return nil, fmt.Errorf("Either tags or --file must be given")
+	}
+	if problems := s.reportConfig.problems(); len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid settings:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	entries, err := ParseInputFile(s.File, s.inputOptions(), s.Separator)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse input file: %v", err)
+	}
+	tags := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		tags = append(tags, entry.Tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func printRuleMatches(out io.Writer, rules tagRules, tags []string) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tRULE\tLANE")
+	for _, tag := range tags {
+		lane, idx := rules.match(tag)
+		if idx < 0 {
+			fmt.Fprintf(w, "%s\t-\t%s\n", tag, lane)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d: %s\t%s\n", tag, idx, rules[idx], lane)
+	}
+	return w.Flush()
+}
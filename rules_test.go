@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRulesMatchNormalizedTags(t *testing.T) {
+	rules, err := loadRules(writeRules(t, `
+rules:
+  - glob: ops-*
+    lane: Support
+  - regex: ^(dev|backend)(:.*)?$
+    lane: Development
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tag  string
+		lane string
+		rule int
+	}{
+		{tag: "ops-oncall", lane: "Support", rule: 0},
+		{tag: " OPS-Oncall ", lane: "Support", rule: 0},
+		{tag: "dev:api", lane: "Development", rule: 1},
+		{tag: "Backend", lane: "Development", rule: 1},
+		{tag: " DEV ", lane: "Development", rule: 1},
+		{tag: "review", lane: "review", rule: -1},
+	}
+	for _, tt := range tests {
+		lane, rule := rules.match(tt.tag)
+		if lane != tt.lane || rule != tt.rule {
+			t.Errorf("match(%q) = %q, %d, want %q, %d", tt.tag, lane, rule, tt.lane, tt.rule)
+		}
+	}
+}
+
+func TestRulesTestReadsInputFormats(t *testing.T) {
+	rules := writeRules(t, "rules:\n  - glob: dev\n    lane: Development\n")
+	rootCmd.SetArgs([]string{"rules", "test", "--rules", rules, "--input-format", "toggl", "--file", "testdata/toggl.csv"})
+	defer rootCmd.SetArgs(nil)
+
+	out := captureStdout(t, rootCmd.Execute)
+	for _, want := range []string{"0: glob dev  Development", "ops", "review"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q lacks %q", out, want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Resolves a secret reference. "file://path" reads the file and
+// "vault://mount/path#field" reads a field of a Vault KV v2 secret. Any
+// other value is the secret itself.
+func resolveSecret(ctx context.Context, ref string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(ref, "file://"):
+		path := strings.TrimPrefix(ref, "file://")
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read secret file: %v", err)
+		}
+		return b, nil
+	case strings.HasPrefix(ref, "vault://"):
+		return readVaultSecret(ctx, strings.TrimPrefix(ref, "vault://"))
+	default:
+		return []byte(ref), nil
+	}
+}
+
+// Reads "mount/path#field" from the KV v2 engine of the Vault server at
+// VAULT_ADDR, authenticating with VAULT_TOKEN or ~/.vault-token.
+func readVaultSecret(ctx context.Context, ref string) ([]byte, error) {
+	idx := strings.LastIndex(ref, "#")
+	if idx < 0 || idx == len(ref)-1 {
+		return nil, fmt.Errorf("Vault reference %q needs a #field", "vault://"+ref)
+	}
+	path, field := strings.Trim(ref[:idx], "/"), ref[idx+1:]
+
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 || len(parts[0]) < 1 || len(parts[1]) < 1 {
+		return nil, fmt.Errorf("Vault reference %q must be vault://mount/path#field", "vault://"+ref)
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if len(addr) < 1 {
+		addr = "https://127.0.0.1:8200"
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(addr, "/"), parts[0], parts[1])
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); len(ns) > 0 {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read secret from Vault: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault answered %s for %s", resp.Status, path)
+	}
+
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("Unable to parse Vault response: %v", err)
+	}
+
+	value, ok := secret.Data.Data[field]
+	if !ok {
+		return nil, fmt.Errorf("Vault secret %s has no field %q", path, field)
+	}
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	// Structured values, e.g. an OAuth client stored as JSON object.
+	return json.Marshal(value)
+}
+
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); len(token) > 0 {
+		return token, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		b, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+		if err == nil {
+			return strings.TrimSpace(string(b)), nil
+		}
+	}
+	return "", fmt.Errorf("Environment variable VAULT_TOKEN not set")
+}
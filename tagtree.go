@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Splits a hierarchical tag like "project:alpha:backend" into its trimmed,
+// non-empty segments. Without a separator the tag is a single segment.
+func splitTag(tag, separator string) []string {
+	if len(separator) < 1 {
+		return []string{strings.TrimSpace(tag)}
+	}
+
+	segments := []string{}
+	for _, segment := range strings.Split(tag, separator) {
+		if segment = strings.TrimSpace(segment); len(segment) > 0 {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// Returns the tag cut to its first depth segments. A depth of 0 keeps all
+// segments.
+func cutTag(tag, separator string, depth int) string {
+	if len(separator) < 1 {
+		return tag
+	}
+
+	segments := splitTag(tag, separator)
+	if depth > 0 && len(segments) > depth {
+		segments = segments[:depth]
+	}
+	return strings.Join(segments, separator)
+}
+
+// Returns the hours summed per tag cut to depth.
+func rollUpLanes(hoursByTag map[string]float64, separator string, depth int) map[string]float64 {
+	if len(separator) < 1 || depth < 1 {
+		return hoursByTag
+	}
+
+	rolledUp := make(map[string]float64)
+	for tag, hours := range hoursByTag {
+		rolledUp[cutTag(tag, separator, depth)] += hours
+	}
+	return rolledUp
+}
+
+// Returns one entry per tag cut to depth with the summed hours, in the order
+// the tags first appear.
+func rollUpEntries(entries []hourTagEntry, separator string, depth int) []hourTagEntry {
+	if len(separator) < 1 || depth < 1 {
+		return entries
+	}
+
+	ret := make([]hourTagEntry, 0, len(entries))
+	index := map[string]int{}
+	for _, entry := range entries {
+		tag := cutTag(entry.Tag, separator, depth)
+		if idx, ok := index[tag]; ok {
+			ret[idx].Hours += entry.Hours
+			continue
+		}
+		index[tag] = len(ret)
+		ret = append(ret, hourTagEntry{Tag: tag, Hours: entry.Hours})
+	}
+	return ret
+}
+
+// tagNode is a segment of hierarchical tags. hours are booked on the node
+// itself, total includes all children.
+type tagNode struct {
+	name     string
+	hours    float64
+	total    float64
+	children []*tagNode
+}
+
+// Builds the tree of the tags below an unnamed root. Children are sorted by
+// their total hours, most first.
+func buildTagTree(entries []hourTagEntry, separator string) *tagNode {
+	root := &tagNode{}
+	for _, entry := range entries {
+		node := root
+		node.total += entry.Hours
+		for _, segment := range splitTag(entry.Tag, separator) {
+			node = node.child(segment)
+			node.total += entry.Hours
+		}
+		node.hours += entry.Hours
+	}
+	root.sort()
+	return root
+}
+
+func (n *tagNode) child(name string) *tagNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &tagNode{name: name}
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *tagNode) sort() {
+	sort.SliceStable(n.children, func(i, j int) bool {
+		if n.children[i].total != n.children[j].total {
+			return n.children[i].total > n.children[j].total
+		}
+		return n.children[i].name < n.children[j].name
+	})
+	for _, c := range n.children {
+		c.sort()
+	}
+}
+
+// outlineRow is a node of the tag tree as a row of an outline.
+type outlineRow struct {
+	node  *tagNode
+	level int
+	// end is the index of the row after the node's subtree.
+	end int
+}
+
+// Returns the nodes below n depth first, each followed by its children.
+func (n *tagNode) outline() []outlineRow {
+	rows := []outlineRow{}
+	var walk func(node *tagNode, level int)
+	walk = func(node *tagNode, level int) {
+		idx := len(rows)
+		rows = append(rows, outlineRow{node: node, level: level})
+		for _, c := range node.children {
+			walk(c, level+1)
+		}
+		rows[idx].end = len(rows)
+	}
+	for _, c := range n.children {
+		walk(c, 0)
+	}
+	return rows
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRollUpEntries(t *testing.T) {
+	entries := []hourTagEntry{
+		{Tag: "project:alpha:backend", Hours: 2},
+		{Tag: "ops", Hours: 1.5},
+		{Tag: "project:alpha:frontend", Hours: 1},
+		{Tag: "project:beta", Hours: 0.5},
+		{Tag: "ops", Hours: 0.25},
+	}
+
+	tests := []struct {
+		name      string
+		separator string
+		depth     int
+		want      []hourTagEntry
+	}{
+		{name: "depth 1", separator: ":", depth: 1, want: []hourTagEntry{
+			{Tag: "project", Hours: 3.5},
+			{Tag: "ops", Hours: 1.75},
+		}},
+		{name: "depth 2", separator: ":", depth: 2, want: []hourTagEntry{
+			{Tag: "project:alpha", Hours: 3},
+			{Tag: "ops", Hours: 1.75},
+			{Tag: "project:beta", Hours: 0.5},
+		}},
+		{name: "deeper than the tags", separator: ":", depth: 5, want: []hourTagEntry{
+			{Tag: "project:alpha:backend", Hours: 2},
+			{Tag: "ops", Hours: 1.75},
+			{Tag: "project:alpha:frontend", Hours: 1},
+			{Tag: "project:beta", Hours: 0.5},
+		}},
+		{name: "depth 0 keeps the entries", separator: ":", depth: 0, want: entries},
+		{name: "no separator keeps the entries", depth: 1, want: entries},
+	}
+	for _, tt := range tests {
+		got := rollUpEntries(entries, tt.separator, tt.depth)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRollUpLanes(t *testing.T) {
+	hours := map[string]float64{
+		"project:alpha:backend":  2,
+		"project:alpha:frontend": 1,
+		"project : beta":         0.5,
+		"ops":                    1.5,
+	}
+
+	tests := []struct {
+		name      string
+		separator string
+		depth     int
+		want      map[string]float64
+	}{
+		{name: "depth 1", separator: ":", depth: 1, want: map[string]float64{"project": 3.5, "ops": 1.5}},
+		{name: "depth 2", separator: ":", depth: 2, want: map[string]float64{"project:alpha": 3, "project:beta": 0.5, "ops": 1.5}},
+		{name: "deeper than the tags", separator: ":", depth: 5, want: map[string]float64{
+			"project:alpha:backend":  2,
+			"project:alpha:frontend": 1,
+			"project:beta":           0.5,
+			"ops":                    1.5,
+		}},
+		{name: "other separator", separator: "/", depth: 1, want: hours},
+		{name: "no separator", depth: 1, want: hours},
+	}
+	for _, tt := range tests {
+		got := rollUpLanes(hours, tt.separator, tt.depth)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
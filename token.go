@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// persistingTokenSource refreshes the token of a store and writes it back,
+// e.g. after a refresh or a rotated refresh token. The refresh runs under
+// the lock of the store, so concurrent runs refresh only once.
+type persistingTokenSource struct {
+	ctx    context.Context
+	config *oauth2.Config
+	store  tokenStore
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func newPersistingTokenSource(ctx context.Context, config *oauth2.Config, store tokenStore, tok *oauth2.Token) *persistingTokenSource {
+	return &persistingTokenSource{
+		ctx:    ctx,
+		config: config,
+		store:  store,
+		last:   tok,
+	}
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last.Valid() {
+		return s.last, nil
+	}
+
+	// Tokens loaded from the legacy token.json in the current directory are
+	// stored in the profile directory, which may not exist yet.
+	if err := os.MkdirAll(filepath.Dir(s.store.Path()), 0700); err != nil {
+		log.Warnf("Unable to create token directory: %v", err)
+	}
+	unlock, err := lockFile(s.store.Path() + ".lock")
+	if err != nil {
+		// Refreshing without the lock is still better than failing the run.
+		log.Warnf("Unable to lock token file: %v", err)
+		unlock = func() {}
+	}
+	defer unlock()
+
+	// Another run may have refreshed the token, and rotated its refresh
+	// token, while this one waited for the lock.
+	base := s.last
+	if stored, err := s.store.Load(); err == nil && stored.RefreshToken != "" {
+		if stored.Valid() {
+			log.Debugf("Using token refreshed by another run from %s", s.store.Path())
+			s.last = stored
+			return stored, nil
+		}
+		base = stored
+	}
+
+	tok, err := s.config.TokenSource(s.ctx, base).Token()
+	if err != nil {
+		return nil, err
+	}
+	if len(tokenScopes(tok)) < 1 {
+		tok = withScopes(tok, tokenScopes(base))
+	}
+	s.last = tok
+
+	// A failed write must not fail the request, the token is still valid.
+	b, err := s.store.encode(tok)
+	if err == nil {
+		err = writeFileAtomic(s.store.Path(), b, 0600)
+	}
+	if err != nil {
+		log.Warnf("Unable to persist refreshed token: %v", err)
+		return tok, nil
+	}
+	log.Debugf("Persisted refreshed token to %s", s.store.Path())
+
+	return tok, nil
+}
+
+// storedToken is the file format of a token: the OAuth token and the scopes
+// it was granted, so a read-only token isn't used to write.
+type storedToken struct {
+	*oauth2.Token
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func encodeToken(token *oauth2.Token) ([]byte, error) {
+	return json.Marshal(storedToken{Token: token, Scopes: tokenScopes(token)})
+}
+
+func decodeToken(b []byte) (*oauth2.Token, error) {
+	stored := storedToken{}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Token == nil {
+		return &oauth2.Token{}, nil
+	}
+	return withScopes(stored.Token, stored.Scopes), nil
+}
+
+// Returns the scopes granted to the token, from the token response or the
+// file it was loaded from. Tokens saved before scopes were recorded have
+// none.
+func tokenScopes(token *oauth2.Token) []string {
+	scope, _ := token.Extra("scope").(string)
+	return strings.Fields(scope)
+}
+
+// Returns a copy of the token granting scopes.
+func withScopes(token *oauth2.Token, scopes []string) *oauth2.Token {
+	if len(scopes) < 1 {
+		return token
+	}
+	return token.WithExtra(map[string]interface{}{"scope": strings.Join(scopes, " ")})
+}
+
+// Writes the token to path atomically with 0600 permissions while holding
+// an exclusive lock, so concurrent runs don't corrupt the file.
+func writeToken(path string, token *oauth2.Token) error {
+	b, err := encodeToken(token)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeLocked(path, b)
+}
+
+// Replaces the file at path with data while holding its lock file.
+func writeLocked(path string, data []byte) error {
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return writeFileAtomic(path, data, 0600)
+}
+
+// Writes data to a temporary file next to path and renames it into place.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Unable to replace %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// refreshServer is a token endpoint rotating the refresh token with every
+// refresh, so a stale one is rejected.
+type refreshServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	refreshes int
+}
+
+func newRefreshServer() *refreshServer {
+	s := &refreshServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if req.Form.Get("refresh_token") != fmt.Sprintf("refresh-%d", s.refreshes) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		s.refreshes++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":"refresh-%d","token_type":"Bearer","expires_in":3600}`, s.refreshes, s.refreshes)
+	}))
+	return s
+}
+
+func (s *refreshServer) config() *oauth2.Config {
+	return &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: s.URL, AuthStyle: oauth2.AuthStyleInParams}}
+}
+
+func TestPersistingTokenSourceRefreshesOnce(t *testing.T) {
+	srv := newRefreshServer()
+	defer srv.Close()
+
+	config := srv.config()
+	store := fileTokenStore{path: filepath.Join(t.TempDir(), "token.json")}
+	expired := &oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(-time.Hour)}
+	if err := store.Save(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two runs start with the same expired token.
+	tokens := make([]*oauth2.Token, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = newPersistingTokenSource(context.Background(), config, store, expired).Token()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if tokens[i].AccessToken != "access-1" {
+			t.Errorf("run %d got %q, want access-1", i, tokens[i].AccessToken)
+		}
+	}
+	if srv.refreshes != 1 {
+		t.Errorf("refreshed %d times, want 1", srv.refreshes)
+	}
+	stored, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RefreshToken != "refresh-1" {
+		t.Errorf("stored refresh token %q, want refresh-1", stored.RefreshToken)
+	}
+}
+
+func TestPersistingTokenSourceCreatesProfileDir(t *testing.T) {
+	srv := newRefreshServer()
+	defer srv.Close()
+
+	// A legacy token from the current directory, the profile directory
+	// doesn't exist yet.
+	store := fileTokenStore{path: filepath.Join(t.TempDir(), "gsheet-updater", defaultProfile, "token.json")}
+	expired := &oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(-time.Hour)}
+	if _, err := newPersistingTokenSource(context.Background(), srv.config(), store, expired).Token(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Load()
+	if err != nil {
+		t.Fatalf("refreshed token not saved: %v", err)
+	}
+	if stored.RefreshToken != "refresh-1" {
+		t.Errorf("stored refresh token %q, want refresh-1", stored.RefreshToken)
+	}
+}
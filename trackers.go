@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Input formats of time tracker exports.
+const (
+	inputToggl    = "toggl"
+	inputClockify = "clockify"
+	inputHarvest  = "harvest"
+)
+
+// Policies for the hours of entries with several tags.
+const (
+	// multiTagSplit splits the hours evenly across the tags.
+	multiTagSplit = "split"
+	// multiTagFull counts the full hours for every tag.
+	multiTagFull = "full"
+)
+
+var multiTagPolicies = []string{multiTagSplit, multiTagFull}
+
+// Filters on the billable flag of entries.
+const (
+	billableAll         = "all"
+	billableOnly        = "billable"
+	billableNonBillable = "non-billable"
+)
+
+var billableFilters = []string{billableAll, billableOnly, billableNonBillable}
+
+// trackerExport describes the columns of a time tracker's detailed CSV
+// export. Each column lists the headers it may have, empty if the export
+// has no such column.
+type trackerExport struct {
+	tags        []string
+	project     []string
+	description []string
+	person      []string
+	// lastName is joined to person if set.
+	lastName  []string
+	billable  []string
+	startDate []string
+	startTime []string
+	endDate   []string
+	endTime   []string
+	duration  []string
+
+	dateLayouts []string
+	timeLayouts []string
+}
+
+var trackerExports = map[string]trackerExport{
+	// Toggl Track, Reports > Detailed > Export CSV
+	inputToggl: {
+		tags:        []string{"Tags"},
+		project:     []string{"Project"},
+		description: []string{"Description"},
+		person:      []string{"User"},
+		billable:    []string{"Billable"},
+		startDate:   []string{"Start date"},
+		startTime:   []string{"Start time"},
+		endDate:     []string{"End date"},
+		endTime:     []string{"End time"},
+		duration:    []string{"Duration"},
+		dateLayouts: []string{"2006-01-02"},
+		timeLayouts: []string{"15:04:05"},
+	},
+	// Clockify, Reports > Detailed > Export CSV. Dates and times follow the
+	// settings of the workspace.
+	inputClockify: {
+		tags:        []string{"Tags"},
+		project:     []string{"Project"},
+		description: []string{"Description"},
+		person:      []string{"User"},
+		billable:    []string{"Billable"},
+		startDate:   []string{"Start Date"},
+		startTime:   []string{"Start Time"},
+		endDate:     []string{"End Date"},
+		endTime:     []string{"End Time"},
+		duration:    []string{"Duration (decimal)", "Duration (h)"},
+		dateLayouts: []string{"01/02/2006", "2006-01-02", "02.01.2006"},
+		timeLayouts: []string{"03:04:05 PM", "03:04 PM", "15:04:05", "15:04"},
+	},
+	// Harvest, Reports > Detailed time > Export CSV. Harvest has no tags,
+	// entries are tagged with their project.
+	inputHarvest: {
+		project:     []string{"Project"},
+		description: []string{"Notes"},
+		person:      []string{"First Name"},
+		lastName:    []string{"Last Name"},
+		billable:    []string{"Billable?"},
+		startDate:   []string{"Date"},
+		duration:    []string{"Hours"},
+		dateLayouts: []string{"2006-01-02"},
+	},
+}
+
+// Returns the time entries of a time tracker's CSV export. Tags are split
+// at commas, entries without tags are tagged with their project.
+func parseTrackerExport(filename string, export trackerExport, options csvOptions) ([]timeEntry, error) {
+	ret := make([]timeEntry, 0)
+
+	rows, err := readCSV(filename, options)
+	if err != nil {
+		return ret, err
+	}
+	if len(rows) == 0 {
+		return ret, fmt.Errorf("%s is empty", filename)
+	}
+	if rows[0].err != nil {
+		return ret, fmt.Errorf("%s: line %d: %v", filename, rows[0].line, rows[0].err)
+	}
+	header, rows := rows[0].fields, rows[1:]
+
+	missing := []string{}
+	column := func(headers []string, required bool) int {
+		idx := optionalColumnIndex(header, headers...)
+		if idx < 0 && required && len(headers) > 0 {
+			missing = append(missing, headers[0])
+		}
+		return idx
+	}
+	tagsIdx := column(export.tags, true)
+	projectIdx := column(export.project, true)
+	descriptionIdx := column(export.description, false)
+	personIdx := column(export.person, false)
+	lastNameIdx := column(export.lastName, false)
+	billableIdx := column(export.billable, false)
+	startDateIdx := column(export.startDate, true)
+	startTimeIdx := column(export.startTime, true)
+	endDateIdx := column(export.endDate, false)
+	endTimeIdx := column(export.endTime, false)
+	durationIdx := column(export.duration, true)
+	if len(missing) > 0 {
+		return ret, fmt.Errorf("%s: missing columns %s, is this the right input format?", filename, strings.Join(missing, ", "))
+	}
+
+	problems := []string{}
+	for _, row := range rows {
+		if row.err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", row.line, row.err))
+			continue
+		}
+		f := row.fields
+
+		entry := timeEntry{
+			Tags:        splitTags(fieldAt(f, tagsIdx)),
+			Person:      strings.TrimSpace(fieldAt(f, personIdx) + " " + fieldAt(f, lastNameIdx)),
+			Description: fieldAt(f, descriptionIdx),
+		}
+		if len(entry.Tags) == 0 {
+			entry.Tags = []string{fieldAt(f, projectIdx)}
+		}
+
+		entry.Start, err = export.parseTime(fieldAt(f, startDateIdx), fieldAt(f, startTimeIdx))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: start: %v", row.line, err))
+			continue
+		}
+		if len(fieldAt(f, endDateIdx)) > 0 {
+			entry.End, err = export.parseTime(fieldAt(f, endDateIdx), fieldAt(f, endTimeIdx))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: end: %v", row.line, err))
+				continue
+			}
+		}
+		entry.Hours, err = options.durations.hours(fieldAt(f, durationIdx))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", row.line, err))
+			continue
+		}
+		entry.Billable, err = parseBillable(fieldAt(f, billableIdx))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", row.line, err))
+			continue
+		}
+
+		ret = append(ret, entry)
+	}
+
+	if len(problems) > 0 {
+		return ret, fmt.Errorf("%d invalid row(s) in %s:\n  %s", len(problems), filename, strings.Join(problems, "\n  "))
+	}
+	return ret, nil
+}
+
+// Returns the local time of a date and an optional time of day.
+func (e trackerExport) parseTime(date, clock string) (time.Time, error) {
+	for _, dateLayout := range e.dateLayouts {
+		if len(clock) < 1 {
+			if t, err := time.ParseInLocation(dateLayout, date, time.Local); err == nil {
+				return t, nil
+			}
+			continue
+		}
+		for _, timeLayout := range e.timeLayouts {
+			if t, err := time.ParseInLocation(dateLayout+" "+timeLayout, date+" "+clock, time.Local); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", strings.TrimSpace(date+" "+clock))
+}
+
+// Splits a comma separated list of tags.
+func splitTags(s string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Returns the billable flag of an entry. Empty is not billable.
+func parseBillable(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid billable flag %q", s)
+}
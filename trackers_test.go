@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Returns the hours per tag of the entries.
+func hoursByTag(entries []hourTagEntry) map[string]float64 {
+	ret := map[string]float64{}
+	for _, entry := range entries {
+		ret[entry.Tag] += entry.Hours
+	}
+	return ret
+}
+
+func assertHours(t *testing.T, got []hourTagEntry, want map[string]float64) {
+	t.Helper()
+	byTag := hoursByTag(got)
+	if len(byTag) != len(want) {
+		t.Errorf("hours = %v, want %v", byTag, want)
+		return
+	}
+	for tag, hours := range want {
+		if math.Abs(byTag[tag]-hours) > 1e-9 {
+			t.Errorf("hours = %v, want %v", byTag, want)
+			return
+		}
+	}
+}
+
+func TestParseTrackerExports(t *testing.T) {
+	june, err := parseDateWindow("2020-06-01", "2020-06-30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format   string
+		multiTag string
+		billable string
+		window   dateWindow
+		want     map[string]float64
+	}{
+		{format: inputToggl, want: map[string]float64{"dev": 2.75, "review": 0.75, "ops": 1.75, "Internal": 0.75}},
+		{format: inputToggl, multiTag: multiTagFull, want: map[string]float64{"dev": 3.5, "review": 1.5, "ops": 1.75, "Internal": 0.75}},
+		{format: inputToggl, billable: billableOnly, want: map[string]float64{"dev": 2.75, "review": 0.75, "ops": 0.5}},
+		{format: inputToggl, billable: billableNonBillable, want: map[string]float64{"ops": 1.25, "Internal": 0.75}},
+		{format: inputToggl, billable: billableOnly, window: june, want: map[string]float64{"dev": 2.75, "review": 0.75}},
+		{format: inputClockify, want: map[string]float64{"dev": 2.75, "review": 0.75, "ops": 1.75, "Internal": 0.75}},
+		{format: inputClockify, multiTag: multiTagFull, want: map[string]float64{"dev": 3.5, "review": 1.5, "ops": 1.75, "Internal": 0.75}},
+		{format: inputClockify, billable: billableOnly, want: map[string]float64{"dev": 2.75, "review": 0.75, "ops": 0.5}},
+		{format: inputClockify, billable: billableNonBillable, want: map[string]float64{"ops": 1.25, "Internal": 0.75}},
+		{format: inputHarvest, want: map[string]float64{"Website": 4, "Internal": 2}},
+		{format: inputHarvest, multiTag: multiTagFull, want: map[string]float64{"Website": 4, "Internal": 2}},
+		{format: inputHarvest, billable: billableOnly, want: map[string]float64{"Website": 4}},
+		{format: inputHarvest, billable: billableNonBillable, want: map[string]float64{"Internal": 2}},
+		{format: inputHarvest, billable: billableOnly, window: june, want: map[string]float64{"Website": 3.5}},
+	}
+	for _, tt := range tests {
+		options := inputOptions{format: tt.format, window: tt.window, billable: tt.billable, multiTag: tt.multiTag}
+		got, err := ParseInputFile("testdata/"+tt.format+".csv", options, "")
+		if err != nil {
+			t.Errorf("%s %s %s: %v", tt.format, tt.multiTag, tt.billable, err)
+			continue
+		}
+		assertHours(t, got, tt.want)
+	}
+}
+
+func TestParseTrackerExportWrongFormat(t *testing.T) {
+	if _, err := ParseInputFile("testdata/harvest.csv", inputOptions{format: inputToggl}, ""); err == nil {
+		t.Error("a Harvest export was read as Toggl export")
+	}
+}